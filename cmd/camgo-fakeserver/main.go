@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/fakeserver"
+	"github.com/darkclainer/camgo/pkg/transport"
+)
+
+const codeErrorArgs = 1
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	dir := flag.String("dir", "pkg/parser/testdata/html", "directory with html fixtures named <lemmaID>.html")
+	latency := flag.Duration("latency", 0, "minimal latency of every response")
+	jitter := flag.Duration("jitter", 0, "maximum random latency added to -latency")
+	errorRate := flag.Float64("error-rate", 0, "probability from 0 to 1 that request fails")
+	errorCode := flag.Int("error-code", http.StatusServiceUnavailable, "status of failed requests")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for random latency and errors")
+	flag.Parse()
+
+	config := &fakeserver.Config{
+		Dir:       *dir,
+		ErrorRate: *errorRate,
+		ErrorCode: *errorCode,
+		Seed:      *seed,
+	}
+	if *latency > 0 || *jitter > 0 {
+		config.Latency = transport.UniformLatency(*latency, *latency+*jitter)
+	}
+	server, err := fakeserver.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can not start server: %s\n", err)
+		os.Exit(codeErrorArgs)
+	}
+	log.Printf("serving fixtures from %s on http://%s", *dir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
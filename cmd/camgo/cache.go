@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// cacheCommands are subcommands of "camgo cache"
+var cacheCommands = map[string]func(args []string){
+	"stats":      cacheStatsCommand,
+	"ls":         cacheListCommand,
+	"show":       cacheShowCommand,
+	"purge":      cachePurgeCommand,
+	"gc":         cacheGCCommand,
+	"reindex":    cacheReindexCommand,
+	"snapshot":   cacheSnapshotCommand,
+	"new-key":    cacheNewKeyCommand,
+	"rotate-key": cacheRotateKeyCommand,
+}
+
+func cacheCommand(args []string) {
+	if len(args) == 0 {
+		exitf(codeErrorArgs, "usage: camgo cache stats|ls|show|purge|gc|reindex|snapshot|new-key|rotate-key [flags]\n")
+	}
+	command, ok := cacheCommands[args[0]]
+	if !ok {
+		exitf(codeErrorArgs, "unknown cache command '%s'\n", args[0])
+	}
+	command(args[1:])
+}
+
+// filterFlags are flags that select records
+type filterFlags struct {
+	types     *string
+	prefix    *string
+	errors    *bool
+	olderThan *time.Duration
+}
+
+func addFilterFlags(flags *flag.FlagSet) *filterFlags {
+	return &filterFlags{
+		types:     flags.String("type", "", "comma separated types of records: query, lemma, page. Default is every type"),
+		prefix:    flags.String("prefix", "", "prefix of query or lemmaID"),
+		errors:    flags.Bool("errors", false, "select only records with errors"),
+		olderThan: flags.Duration("older-than", 0, "select only records created earlier, e.g. 720h"),
+	}
+}
+
+func (ff *filterFlags) filter() *querier.RecordFilter {
+	filter := &querier.RecordFilter{
+		Prefix:    *ff.prefix,
+		Errors:    *ff.errors,
+		OlderThan: *ff.olderThan,
+	}
+	if *ff.types != "" {
+		filter.Types = strings.Split(*ff.types, ",")
+	}
+	return filter
+}
+
+func (ff *filterFlags) empty() bool {
+	return *ff.types == "" && *ff.prefix == "" && !*ff.errors && *ff.olderThan == 0
+}
+
+func cacheStatsCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache stats", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	_ = flags.Parse(args)
+
+	storage := openStorage(cache)
+	summary, err := storage.Summary(nil)
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) // nolint:gomnd // padding
+	header := []string{"TYPE", "RECORDS", "ERRORS", "BROKEN", "NEWER", "BYTES"}
+	for _, bucket := range summary.AgeBuckets {
+		header = append(header, "<"+formatAge(bucket))
+	}
+	header = append(header, "OLDER")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	types := make([]string, 0, len(summary.Types))
+	for recordType := range summary.Types {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	for _, recordType := range types {
+		s := summary.Types[recordType]
+		row := []string{
+			recordType,
+			fmt.Sprint(s.Records),
+			fmt.Sprint(s.Errors),
+			fmt.Sprint(s.Broken),
+			fmt.Sprint(s.Newer),
+			fmt.Sprint(s.Bytes),
+		}
+		for _, count := range s.Ages {
+			row = append(row, fmt.Sprint(count))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	_ = w.Flush()
+	if summary.DiskSize >= 0 {
+		fmt.Printf("size on disk: %d bytes\n", summary.DiskSize)
+	}
+}
+
+// formatAge formats whole days and hours shortly, like 7d or 1h
+func formatAge(d time.Duration) string {
+	const day = time.Hour * 24
+	switch {
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return d.String()
+	}
+}
+
+func cacheListCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache ls", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	filter := addFilterFlags(flags)
+	_ = flags.Parse(args)
+
+	storage := openStorage(cache)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) // nolint:gomnd // padding
+	fmt.Fprintln(w, "KEY\tCREATED\tEXPIRES\tBYTES\tERROR")
+	err := storage.List(filter.filter(), func(info *querier.RecordInfo) error {
+		created, expires, errorMsg := "-", "never", info.Error
+		if !info.CreatedAt.IsZero() {
+			created = info.CreatedAt.Format(time.RFC3339)
+		}
+		if !info.ExpiresAt.IsZero() {
+			expires = info.ExpiresAt.Format(time.RFC3339)
+		}
+		switch {
+		case info.NewerSchema:
+			errorMsg = "newer schema: " + info.DecodeErr.Error()
+		case info.DecodeErr != nil:
+			errorMsg = "broken: " + info.DecodeErr.Error()
+		}
+		fmt.Fprintf(w, "%s:%s\t%s\t%s\t%d\t%s\n", info.Type, info.Key, created, expires, info.Size, errorMsg)
+		return nil
+	})
+	_ = w.Flush()
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+}
+
+func cacheShowCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache show", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	html := flags.Bool("html", false, "print html of archived page instead of its description")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitf(codeErrorArgs, "you should specify one key like lemma:hello\n")
+	}
+	recordType, key, err := querier.ParseRecordKey(flags.Arg(0))
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	storage := openStorage(cache)
+	defer closeStorage(storage)
+	var record interface{}
+	switch recordType {
+	case "query":
+		record, err = storage.GetQuery(key)
+	case "lemma":
+		record, err = storage.GetLemma(key)
+	case "page":
+		var page *querier.CachedPage
+		page, err = storage.GetPage(key)
+		if err == nil {
+			record, err = pageDescription(page, *html)
+		}
+	}
+	if err != nil {
+		closeStorage(storage)
+		exitf(codeNotFound, "%s\n", err)
+	}
+	if content, ok := record.([]byte); ok {
+		_, _ = os.Stdout.Write(content)
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(record)
+}
+
+// pageDescription returns html of page or its description
+func pageDescription(page *querier.CachedPage, html bool) (interface{}, error) {
+	content, err := page.HTML()
+	if err != nil {
+		return nil, err
+	}
+	if html {
+		return content, nil
+	}
+	return map[string]interface{}{
+		"URL":       page.URL,
+		"FetchedAt": page.FetchedAt,
+		"Size":      len(content),
+	}, nil
+}
+
+func cachePurgeCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache purge", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	filter := addFilterFlags(flags)
+	all := flags.Bool("all", false, "allow purge without filters, it deletes every record")
+	dryRun := flags.Bool("dry-run", false, "only count records that would be deleted")
+	_ = flags.Parse(args)
+
+	if filter.empty() && !*all {
+		exitf(codeErrorArgs, "you should specify filters or -all\n")
+	}
+	storage := openStorage(cache)
+	purged, err := storage.Purge(filter.filter(), *dryRun)
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "purge failed after %d records: %s\n", purged, err)
+	}
+	if *dryRun {
+		fmt.Printf("%d records would be deleted\n", purged)
+		return
+	}
+	fmt.Printf("deleted %d records\n", purged)
+}
+
+func cacheGCCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache gc", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	discardRatio := flags.Float64("discard-ratio", store.DefaultDiscardRatio, "badger rewrites value log files with this part of garbage")
+	flatten := flags.Bool("flatten", false, "compact LSM tree of badger before garbage collection")
+	_ = flags.Parse(args)
+
+	storage := openStorage(cache)
+	if *flatten {
+		if err := storage.Flatten(); err != nil {
+			closeStorage(storage)
+			exitf(codeInternalError, "flatten failed: %s\n", err)
+		}
+	}
+	err := storage.CollectGarbage(*discardRatio)
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "garbage collection failed: %s\n", err)
+	}
+}
+
+func cacheReindexCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache reindex", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	_ = flags.Parse(args)
+
+	storage := openStorage(cache)
+	indexed, err := storage.Reindex()
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "indexed %d lemmas\n", indexed)
+}
+
+// cacheSnapshotCommand builds read-only snapshot for -cache-snapshot from records of cache.
+// Snapshot is not encrypted, even if cache is
+func cacheSnapshotCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache snapshot", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	filter := addFilterFlags(flags)
+	output := flags.String("o", "", "directory of new snapshot, it should not exist or be empty")
+	keepErrors := flags.Bool("keep-errors", false, "copy records with errors too, by default they are skipped")
+	_ = flags.Parse(args)
+
+	if *output == "" {
+		exitf(codeErrorArgs, "you should specify output directory with -o\n")
+	}
+	if entries, err := ioutil.ReadDir(*output); err == nil && len(entries) != 0 {
+		exitf(codeErrorArgs, "output directory '%s' is not empty\n", *output)
+	}
+	recordFilter := filter.filter()
+	recordFilter.WithoutErrors = !*keepErrors && !recordFilter.Errors
+	storage := openStorage(cache)
+	snapshot, err := store.OpenBadger(*output)
+	if err != nil {
+		closeStorage(storage)
+		exitf(codeInternalError, "can not create snapshot: %s\n", err)
+	}
+	copied, err := storage.Snapshot(snapshot, recordFilter)
+	closeStorage(storage)
+	if err == nil {
+		// snapshot is never changed, so it's compacted once
+		err = snapshot.Flatten()
+	}
+	if err == nil {
+		err = snapshot.CollectGarbage(0)
+	}
+	if closeErr := snapshot.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		exitf(codeInternalError, "can not create snapshot: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "copied %d records to %s\n", copied, *output)
+}
+
+// cacheNewKeyCommand prints random encryption key for -cache-key-file or CAMGO_CACHE_KEY
+func cacheNewKeyCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache new-key", flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	key, err := store.GenerateEncryptionKey()
+	if err != nil {
+		exitf(codeInternalError, "can not generate key: %s\n", err)
+	}
+	fmt.Println(key)
+}
+
+func cacheRotateKeyCommand(args []string) {
+	flags := flag.NewFlagSet("camgo cache rotate-key", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	newKeyFile := flags.String("new-key-file", "", "file with new hex encoded encryption key")
+	_ = flags.Parse(args)
+
+	if !cache.enabled() || *cache.backend != "badger" {
+		exitf(codeErrorArgs, "you should specify badger cache\n")
+	}
+	if *newKeyFile == "" {
+		exitf(codeErrorArgs, "you should specify -new-key-file\n")
+	}
+	oldKey, err := cache.encryptionKey()
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	newKey, err := readEncryptionKey(*newKeyFile)
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	err = store.RotateBadgerKey(*cache.path, oldKey, newKey)
+	switch {
+	case errors.Is(err, store.ErrNotEncrypted):
+		exitf(codeErrorArgs, "cache is not encrypted, export it and import into cache opened with new key\n")
+	case err != nil:
+		exitf(codeInternalError, "can not rotate key: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "cache is encrypted with new key, old key can't open it anymore\n")
+}
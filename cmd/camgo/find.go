@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+// findCommand selects cached lemmas by indexes without requests to dictionary
+func findCommand(args []string) {
+	flags := flag.NewFlagSet("camgo find", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	query := &querier.LemmaQuery{}
+	flags.StringVar(&query.Headword, "headword", "", "headword of lemma, e.g. 'get out'")
+	flags.StringVar(&query.PartOfSpeech, "pos", "", "part of speech, e.g. noun or 'phrasal verb'")
+	flags.StringVar(&query.Grammar, "grammar", "", "grammar code without brackets, e.g. U or T")
+	flags.StringVar(&query.Language, "language", "", "dictionary: british, american-english or business-english")
+	kind := flags.String("kind", "", "kind of entry: word, phrasal-verb, idiom or phrase")
+	asJSON := flags.Bool("json", false, "print every lemma as JSON line")
+	_ = flags.Parse(args)
+	query.Kind = querier.EntryKind(*kind)
+
+	storage := openStorage(cache)
+	encoder := json.NewEncoder(os.Stdout)
+	found := 0
+	err := storage.FindLemmas(query, func(lemma *querier.IndexedLemma) error {
+		found++
+		if *asJSON {
+			return encoder.Encode(lemma)
+		}
+		fmt.Println(formatIndexedLemma(lemma))
+		return nil
+	})
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	if found == 0 {
+		exitf(codeNotFound, "nothing found, lemmas cached by older versions are found after 'camgo cache reindex'\n")
+	}
+}
+
+// formatIndexedLemma returns line like "print (noun) [U] british: definition"
+func formatIndexedLemma(lemma *querier.IndexedLemma) string {
+	var sb strings.Builder
+	sb.WriteString(lemma.Lemma.Lemma)
+	if len(lemma.Lemma.PartOfSpeech) != 0 {
+		fmt.Fprintf(&sb, " (%s)", strings.Join(lemma.Lemma.PartOfSpeech, ", "))
+	}
+	if len(lemma.Lemma.Grammar) != 0 {
+		fmt.Fprintf(&sb, " [%s]", strings.Join(lemma.Lemma.Grammar, ", "))
+	}
+	fmt.Fprintf(&sb, " %s: %s", lemma.Lemma.Language, lemma.Lemma.Definition)
+	return sb.String()
+}
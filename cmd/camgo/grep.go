@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+// grepCommand searches cached definitions, examples and guide words without requests to dictionary
+func grepCommand(args []string) {
+	flags := flag.NewFlagSet("camgo grep", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	limit := flags.Int("n", 10, "maximum number of lemmas, 0 means no limit")
+	asJSON := flags.Bool("json", false, "print every match as JSON line")
+	_ = flags.Parse(args)
+
+	text := strings.Join(flags.Args(), " ")
+	if strings.TrimSpace(text) == "" {
+		exitf(codeErrorArgs, "usage: camgo grep [flags] text\n")
+	}
+	storage := openStorage(cache)
+	matches, err := storage.SearchText(text, *limit)
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	if len(matches) == 0 {
+		exitf(codeNotFound, "nothing found, lemmas cached by older versions are found after 'camgo cache reindex'\n")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, match := range matches {
+		if *asJSON {
+			if err := encoder.Encode(match); err != nil {
+				exitf(codeInternalError, "%s\n", err)
+			}
+			continue
+		}
+		fmt.Printf("%.2f\t%s\n", match.Score, formatIndexedLemma(&querier.IndexedLemma{
+			LemmaID: match.LemmaID,
+			Lemma:   match.Lemma,
+		}))
+	}
+}
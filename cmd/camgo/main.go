@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -24,33 +25,56 @@ func exitf(code int, format string, args ...interface{}) {
 	os.Exit(code)
 }
 
+// commands are subcommands of camgo. Without subcommand camgo looks up query
+var commands = map[string]func(args []string){
+	"reparse": reparseCommand,
+	"migrate": migrateCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+	"cache":   cacheCommand,
+	"warm":    warmCommand,
+	"find":    findCommand,
+	"grep":    grepCommand,
+	"peer":    peerCommand,
+}
+
 func main() {
-	query := flag.String("q", "", "query that you want to search in the web")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+	lookupCommand(os.Args[1:])
+}
+
+func lookupCommand(args []string) {
+	flags := flag.NewFlagSet("camgo", flag.ExitOnError)
+	query := flags.String("q", "", "query that you want to search in the web")
+	qf := addQuerierFlags(flags)
+	_ = flags.Parse(args)
 
 	if *query == "" {
 		exitf(codeErrorArgs, "you should specify arguments\n")
 	}
-	q := querier.NewQuerier(nil, nil, &querier.Config{
-		ExtraHeader: map[string]string{
-			"User-Agent": "Mozilla/5.0 (X11; Linux x86_64; rv:74.0) Gecko/20100101 Firefox/74.0",
-		},
-	})
+	q, err := qf.newQuerier()
+	if err != nil {
+		exitf(codeErrorArgs, "can not create querier: %s\n", err)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*timeoutSecounds)
 	defer cancel()
 
-	lemmaID, suggestions, err := q.Search(ctx, *query)
-	if err != nil {
-		exitf(codeInternalError, "unknown error: %s\n", err)
+	result, err := q.Lookup(ctx, *query)
+	if closeErr := q.Close(ctx); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "can not close querier: %s\n", closeErr)
 	}
-	if len(suggestions) > 0 {
-		exitf(codeNotFound, "May be you mean:\n%s\n", strings.Join(suggestions, "\n"))
-	}
-	lemmas, err := q.GetLemma(ctx, lemmaID)
-	if err != nil {
+	switch {
+	case errors.Is(err, querier.ErrSuggestions):
+		exitf(codeNotFound, "May be you mean:\n%s\n", strings.Join(result.Suggestions, "\n"))
+	case err != nil:
 		exitf(codeInternalError, "unknown error: %s\n", err)
 	}
-	s, err := json.MarshalIndent(lemmas, "", "\t")
+	s, err := json.MarshalIndent(result.Lemmas, "", "\t")
 	if err != nil {
 		exitf(codeErrorArgs, "can not marshal word: %s\n", err.Error())
 	}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+func migrateCommand(args []string) {
+	flags := flag.NewFlagSet("camgo migrate", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	encoding := addEncodingFlag(flags)
+	dryRun := flags.Bool("dry-run", false, "only report records that would be upgraded")
+	_ = flags.Parse(args)
+
+	recordEncoding, err := querier.ParseRecordEncoding(*encoding)
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	storage := openStorage(cache)
+	storage.Encoding = recordEncoding
+
+	var total, upgraded, failed int
+	err = storage.Migrate(*dryRun, func(result *querier.MigrationResult) {
+		total++
+		kind := "query"
+		if result.IsLemma {
+			kind = "lemma"
+		}
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", kind, result.Key, result.Err)
+		case result.Upgraded:
+			upgraded++
+			fmt.Printf("%s %s: schema %d\n", kind, result.Key, result.From)
+		}
+	})
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "migration failed: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "checked %d records: %d upgraded to schema %d, %d failed\n",
+		total, upgraded, querier.CurrentSchemaVersion, failed)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+// peerCommand serves cache to other camgo instances listed in the same peer list
+func peerCommand(args []string) {
+	flags := flag.NewFlagSet("camgo peer", flag.ExitOnError)
+	qf := addQuerierFlags(flags)
+	listen := flags.String("listen", ":8080", "address to listen for requests of peers")
+	self := flags.String("self", "", "base URL of this instance as it's listed in peers")
+	_ = flags.Parse(args)
+
+	if !qf.cache.enabled() {
+		exitf(codeErrorArgs, "you should specify cache\n")
+	}
+	if *self == "" {
+		exitf(codeErrorArgs, "you should specify -self\n")
+	}
+	if *qf.peers == "" && *qf.peersFile == "" {
+		exitf(codeErrorArgs, "you should specify -peers or -peers-file\n")
+	}
+	qf.self = *self
+	q, err := qf.newQuerier()
+	if err != nil {
+		exitf(codeErrorArgs, "can not create querier: %s\n", err)
+	}
+	cached := q.(*querier.Cached)
+	mux := http.NewServeMux()
+	mux.Handle(querier.PeerBasePath, cached.PeerHandler())
+	server := &http.Server{Addr: *listen, Handler: mux}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	// cached is closed only after requests in progress are finished
+	shutdown := make(chan struct{})
+	go func() {
+		<-interrupt
+		_ = server.Shutdown(context.Background())
+		close(shutdown)
+	}()
+	fmt.Fprintf(os.Stderr, "serving peers on %s\n", *listen)
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdown
+	}
+	if closeErr := cached.Close(context.Background()); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "can not close querier: %s\n", closeErr)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		exitf(codeInternalError, "%s\n", err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+const userAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:74.0) Gecko/20100101 Firefox/74.0"
+
+// normalizeUsage warns that queries are cached under normalized form
+const normalizeUsage = "normalization of queries: all, none or comma separated case, space, nfc, apostrophe. " +
+	"Queries cached with other normalization are not found, purge them with 'camgo cache purge -type query'"
+
+// querierFlags are flags of commands that make requests to dictionary
+type querierFlags struct {
+	host      *string
+	protocol  *string
+	cache     *cacheFlags
+	archive   *bool
+	encoding  *string
+	rate      *float64
+	normalize *string
+	snapshot  *string
+	peers     *string
+	peersFile *string
+	// self is base URL of this instance in peer list, it's set only by instances that serve peers
+	self string
+}
+
+func addQuerierFlags(flags *flag.FlagSet) *querierFlags {
+	return &querierFlags{
+		host:      flags.String("host", "", "dictionary host, e.g. address of camgo-fakeserver"),
+		protocol:  flags.String("protocol", "", "protocol of dictionary host: http or https"),
+		cache:     addCacheFlags(flags),
+		archive:   flags.Bool("archive", false, "store raw pages in cache, so they can be reparsed later"),
+		encoding:  addEncodingFlag(flags),
+		rate:      flags.Float64("rate", 0, "maximum requests per second to dictionary, 0 means no limit"),
+		normalize: flags.String("normalize", "none", normalizeUsage),
+		snapshot:  flags.String("cache-snapshot", "", "directory of read-only badger snapshot, records missing in cache are read from it"),
+		peers:     flags.String("peers", "", "comma separated base URLs of camgo peers that share cache, like http://10.0.0.1:8080"),
+		peersFile: flags.String("peers-file", "", "file with base URLs of camgo peers, one per line"),
+	}
+}
+
+// cacheKeyEnv is environment variable with hex encoded encryption key of cache
+const cacheKeyEnv = "CAMGO_CACHE_KEY"
+
+// cacheFlags specify where and how cache is stored
+type cacheFlags struct {
+	path        *string
+	backend     *string
+	keyFile     *string
+	redisPrefix *string
+}
+
+func addCacheFlags(flags *flag.FlagSet) *cacheFlags {
+	return &cacheFlags{
+		path:        flags.String("cache", "", "directory of badger cache, file of bolt cache or url of redis cache, without it nothing is cached"),
+		backend:     flags.String("cache-backend", "badger", "storage of cache: badger, bolt or redis"),
+		keyFile:     flags.String("cache-key-file", "", "file with hex encoded encryption key of badger cache, default is $"+cacheKeyEnv),
+		redisPrefix: flags.String("cache-redis-prefix", "camgo:", "prefix of keys in redis cache, processes with the same prefix share cache"),
+	}
+}
+
+// encryptionKey reads key from key file or environment, it returns nil if cache is not encrypted
+func (cf *cacheFlags) encryptionKey() ([]byte, error) {
+	if *cf.keyFile != "" {
+		return readEncryptionKey(*cf.keyFile)
+	}
+	if env := os.Getenv(cacheKeyEnv); env != "" {
+		key, err := store.ParseEncryptionKey(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", cacheKeyEnv, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+func readEncryptionKey(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read key file: %w", err)
+	}
+	key, err := store.ParseEncryptionKey(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file '%s': %w", path, err)
+	}
+	return key, nil
+}
+
+func addEncodingFlag(flags *flag.FlagSet) *string {
+	return flags.String("cache-encoding", "json", "encoding of written records: json, msgpack, msgpack+snappy or msgpack+zstd")
+}
+
+// enabled reports if cache path is specified
+func (cf *cacheFlags) enabled() bool {
+	return *cf.path != ""
+}
+
+func (cf *cacheFlags) open() (store.CacheStore, error) {
+	key, err := cf.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil && *cf.backend != "badger" {
+		return nil, errors.New("encryption is supported only by badger cache")
+	}
+	var cache store.CacheStore
+	switch *cf.backend {
+	case "badger":
+		cache, err = store.OpenBadgerWithOptions(*cf.path, &store.BadgerOptions{EncryptionKey: key})
+	case "bolt":
+		cache, err = store.OpenBolt(*cf.path)
+	case "redis":
+		cache, err = store.OpenRedis(*cf.path, *cf.redisPrefix)
+	default:
+		return nil, fmt.Errorf("unknown cache backend '%s'", *cf.backend)
+	}
+	if errors.Is(err, store.ErrWrongEncryptionKey) {
+		return nil, fmt.Errorf("can not open cache: %w, check -cache-key-file or %s", err, cacheKeyEnv)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not open cache: %w", err)
+	}
+	return cache, nil
+}
+
+// newQuerier returns querier that uses cache if it's specified
+func (qf *querierFlags) newQuerier() (querier.QueryInterface, error) {
+	q := querier.NewQuerier(nil, nil, &querier.Config{
+		ExtraHeader: map[string]string{
+			"User-Agent": userAgent,
+		},
+		Host:      *qf.host,
+		Protocol:  *qf.protocol,
+		RateLimit: *qf.rate,
+	})
+	if !qf.cache.enabled() {
+		if *qf.snapshot != "" {
+			return nil, errors.New("-cache-snapshot requires -cache")
+		}
+		if *qf.peers != "" || *qf.peersFile != "" {
+			return nil, errors.New("-peers and -peers-file require -cache")
+		}
+		return q, nil
+	}
+	encoding, err := querier.ParseRecordEncoding(*qf.encoding)
+	if err != nil {
+		return nil, err
+	}
+	normalization, err := querier.ParseQueryNormalization(*qf.normalize)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := qf.peerConfig()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := qf.cache.open()
+	if err != nil {
+		return nil, err
+	}
+	config := &querier.CachedConfig{
+		ArchivePages:  *qf.archive,
+		Encoding:      encoding,
+		Normalization: normalization,
+		Peers:         peers,
+	}
+	if *qf.snapshot != "" {
+		// snapshot is opened read-only, so it can be shared by several users and processes
+		snapshot, err := store.OpenBadgerWithOptions(*qf.snapshot, &store.BadgerOptions{ReadOnly: true})
+		if err != nil {
+			cache.Close()
+			return nil, fmt.Errorf("can not open cache snapshot: %w", err)
+		}
+		config.Snapshot = snapshot
+	}
+	return querier.NewCached(q, cache, config), nil
+}
+
+// peerConfig returns peers from -peers and -peers-file or nil if there are no peers
+func (qf *querierFlags) peerConfig() (*querier.PeerConfig, error) {
+	var peers []string
+	if *qf.peers != "" {
+		peers = strings.Split(*qf.peers, ",")
+	}
+	if *qf.peersFile != "" {
+		filePeers, err := querier.LoadPeers(*qf.peersFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read peers: %w", err)
+		}
+		peers = append(peers, filePeers...)
+	}
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	return &querier.PeerConfig{Self: qf.self, Peers: peers}, nil
+}
+
+// openStorage opens cache for commands that work with storage directly, it exits on failure
+func openStorage(cache *cacheFlags) *querier.Storage {
+	if !cache.enabled() {
+		exitf(codeErrorArgs, "you should specify cache\n")
+	}
+	cacheStore, err := cache.open()
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	return &querier.Storage{Store: cacheStore}
+}
+
+func closeStorage(storage *querier.Storage) {
+	if err := storage.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "can not close cache: %s\n", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+func reparseCommand(args []string) {
+	flags := flag.NewFlagSet("camgo reparse", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "only report lemmas that would change")
+	_ = flags.Parse(args)
+
+	storage := openStorage(cache)
+
+	var total, changed, failed int
+	err := storage.Reparse(&querier.HTMLParser{}, *dryRun, func(result *querier.ReparseResult) {
+		total++
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", result.LemmaID, result.Err)
+		case result.Changed:
+			changed++
+			fmt.Printf("%s\n", result.LemmaID)
+		}
+	})
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "reparse failed: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "reparsed %d pages: %d changed, %d failed\n", total, changed, failed)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("camgo export", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	format := flags.String("format", "jsonl", "format of export: jsonl or tar.gz")
+	output := flags.String("o", "", "output file, default is stdout")
+	_ = flags.Parse(args)
+
+	exportFormat, err := querier.ParseExportFormat(*format)
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			exitf(codeInternalError, "can not create output: %s\n", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	storage := openStorage(cache)
+
+	var exported, failed int
+	err = storage.Export(w, exportFormat, func(result *querier.TransferResult) {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", result.Type, result.Key, result.Err)
+			return
+		}
+		exported++
+	})
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d records, %d failed\n", exported, failed)
+}
+
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("camgo import", flag.ExitOnError)
+	cache := addCacheFlags(flags)
+	encoding := addEncodingFlag(flags)
+	merge := flags.String("merge", "newer", "what to do with stored records: newer keeps newer one, overwrite or skip")
+	_ = flags.Parse(args)
+
+	strategy, err := querier.ParseMergeStrategy(*merge)
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	recordEncoding, err := querier.ParseRecordEncoding(*encoding)
+	if err != nil {
+		exitf(codeErrorArgs, "%s\n", err)
+	}
+	var r io.Reader = os.Stdin
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			exitf(codeErrorArgs, "can not open input: %s\n", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	storage := openStorage(cache)
+	storage.Encoding = recordEncoding
+
+	var imported, skipped, failed int
+	err = storage.Import(r, strategy, func(result *querier.TransferResult) {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", result.Type, result.Key, result.Err)
+		case result.Skipped:
+			skipped++
+		default:
+			imported++
+		}
+	})
+	closeStorage(storage)
+	if err != nil {
+		exitf(codeInternalError, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d records, %d skipped, %d failed\n", imported, skipped, failed)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+)
+
+func warmCommand(args []string) {
+	flags := flag.NewFlagSet("camgo warm", flag.ExitOnError)
+	qf := addQuerierFlags(flags)
+	concurrency := flags.Int("concurrency", 4, "how many words are fetched at the same time")
+	quiet := flags.Bool("quiet", false, "don't print progress")
+	_ = flags.Parse(args)
+
+	if !qf.cache.enabled() {
+		exitf(codeErrorArgs, "you should specify cache\n")
+	}
+	var r io.Reader = os.Stdin
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			exitf(codeErrorArgs, "can not open word list: %s\n", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	words, err := readWords(r)
+	if err != nil {
+		exitf(codeErrorArgs, "can not read word list: %s\n", err)
+	}
+	q, err := qf.newQuerier()
+	if err != nil {
+		exitf(codeErrorArgs, "can not create querier: %s\n", err)
+	}
+	cached := q.(*querier.Cached)
+
+	// interrupted warming is resumed by the next run, so finish gracefully on Ctrl+C
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	report := cached.Warm(ctx, words, &querier.WarmOptions{
+		Concurrency: *concurrency,
+		Progress: func(progress *querier.WarmProgress) {
+			if *quiet {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "\r[%d/%d] skipped %d, failed %d", progress.Done, progress.Total,
+				progress.Skipped, progress.Failed)
+		},
+	})
+	signal.Stop(interrupt)
+	cancel()
+	if !*quiet && report.Total > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+	if closeErr := cached.Close(context.Background()); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "can not close querier: %s\n", closeErr)
+	}
+
+	for _, notFound := range report.NotFound {
+		fmt.Printf("not found: %s", notFound.Query)
+		if len(notFound.Suggestions) != 0 {
+			fmt.Printf(" (may be you mean: %s)", strings.Join(notFound.Suggestions, ", "))
+		}
+		fmt.Println()
+	}
+	for _, failure := range report.Failures {
+		fmt.Printf("failed: %s: %s\n", failure.Query, failure.Err)
+	}
+	fmt.Fprintf(os.Stderr, "warmed %d words: %d fetched, %d already cached, %d not found, %d failed\n",
+		report.Total, report.Fetched, report.Skipped, len(report.NotFound), len(report.Failures))
+	switch {
+	case report.Interrupted != 0:
+		exitf(codeInternalError, "interrupted, %d words left, run the same command to resume\n", report.Interrupted)
+	case len(report.Failures) != 0:
+		os.Exit(codeInternalError)
+	}
+}
+
+// readWords reads one word or phrase per line, empty lines and lines starting with # are skipped
+func readWords(r io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
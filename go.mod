@@ -4,9 +4,18 @@ go 1.14
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.1
+	github.com/alicebob/miniredis/v2 v2.11.4
+	github.com/andybalholm/brotli v1.0.0
 	github.com/andybalholm/cascadia v1.1.0
 	github.com/dgraph-io/badger/v2 v2.0.3
-	github.com/gammazero/workerpool v0.0.0-20200311205957-7b00833861c6
+	github.com/go-redis/redis/v7 v7.4.0
+	github.com/golang/snappy v0.0.1
+	github.com/klauspost/compress v1.10.5
+	github.com/kljensen/snowball v0.6.0
 	github.com/stretchr/testify v1.5.1
 	github.com/vektra/mockery v0.0.0-20181123154057-e78b021dcbb5
+	github.com/vmihailenco/msgpack/v4 v4.3.11
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/text v0.3.2
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 )
@@ -0,0 +1,273 @@
+// Package fakeserver serves dictionary pages from local fixtures the same way dictionary.cambridge.org does,
+// so querier can be tested without network.
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/transport"
+)
+
+const (
+	lemmaPath      = "/dictionary/english/"
+	suggestionPath = "/spellcheck/english/"
+	searchPath     = "/search/english/direct/"
+
+	pageExt          = ".html"
+	spellcheckDir    = "spellcheck"
+	aliasesFile      = "aliases.json"
+	maxSuggestions   = 10
+	defaultErrorCode = http.StatusServiceUnavailable
+)
+
+// Config specifies fixtures and misbehavior of Server
+type Config struct {
+	// Dir contains fixtures:
+	// <lemmaID>.html are dictionary pages,
+	// spellcheck/<query>.html are optional suggestion pages, others are generated from known lemmaIDs,
+	// aliases.json is optional JSON object that maps queries to lemmaIDs.
+	Dir string
+	// Latency specifies delay of every response
+	Latency transport.LatencyFunc
+	// ErrorRate is probability from 0 to 1 that request fails with ErrorCode
+	ErrorRate float64
+	// ErrorCode is status of failed requests, default is 503
+	ErrorCode int
+	// Seed of random generator for latency and errors
+	Seed int64
+}
+
+// Server is http.Handler that mimics search, spellcheck and dictionary routes of Cambridge dictionary
+type Server struct {
+	config  *Config
+	mux     *http.ServeMux
+	lemmas  map[string]bool
+	aliases map[string]string
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New loads list of fixtures from config.Dir. Pages themselves are read on every request,
+// so they can be edited while server is running.
+func New(config *Config) (*Server, error) {
+	if config.ErrorCode == 0 {
+		config.ErrorCode = defaultErrorCode
+	}
+	pages, err := filepath.Glob(filepath.Join(config.Dir, "*"+pageExt))
+	if err != nil {
+		return nil, fmt.Errorf("can not list fixtures: %w", err)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no fixtures found in '%s'", config.Dir)
+	}
+	lemmas := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		lemmas[strings.TrimSuffix(filepath.Base(page), pageExt)] = true
+	}
+	aliases, err := loadAliases(filepath.Join(config.Dir, aliasesFile))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		config:  config,
+		mux:     http.NewServeMux(),
+		lemmas:  lemmas,
+		aliases: aliases,
+		rand:    rand.New(rand.NewSource(config.Seed)), // nolint:gosec // it's not for security
+	}
+	s.mux.HandleFunc(searchPath, s.handleSearch)
+	s.mux.HandleFunc(suggestionPath, s.handleSpellcheck)
+	s.mux.HandleFunc(lemmaPath, s.handleLemma)
+	return s, nil
+}
+
+func loadAliases(aliasesPath string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	content, err := ioutil.ReadFile(aliasesPath)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not read aliases: %w", err)
+	}
+	if err := json.Unmarshal(content, &aliases); err != nil {
+		return nil, fmt.Errorf("can not decode aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	delay, fail := s.misbehavior()
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+	if fail {
+		http.Error(w, "simulated error", s.config.ErrorCode)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) misbehavior() (delay time.Duration, fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config.Latency != nil {
+		delay = s.config.Latency(s.rand)
+	}
+	fail = s.rand.Float64() < s.config.ErrorRate
+	return delay, fail
+}
+
+// handleSearch redirects to dictionary page if query is known and to spellcheck page otherwise
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		http.Redirect(w, r, lemmaPath, http.StatusFound)
+		return
+	}
+	if lemmaID, ok := s.resolve(query); ok {
+		http.Redirect(w, r, path.Join(lemmaPath, lemmaID), http.StatusFound)
+		return
+	}
+	values := url.Values{}
+	values.Set("q", query)
+	http.Redirect(w, r, suggestionPath+"?"+values.Encode(), http.StatusFound)
+}
+
+func (s *Server) resolve(query string) (string, bool) {
+	if lemmaID, ok := s.aliases[query]; ok {
+		return lemmaID, true
+	}
+	lemmaID := queryToLemmaID(query)
+	return lemmaID, s.lemmas[lemmaID]
+}
+
+// queryToLemmaID converts query to lemmaID like "Get out" to "get-out"
+func queryToLemmaID(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), "-")
+}
+
+func (s *Server) handleLemma(w http.ResponseWriter, r *http.Request) {
+	lemmaID := strings.TrimPrefix(r.URL.Path, lemmaPath)
+	if lemmaID == "" {
+		// Cambridge shows its main page here
+		_, _ = w.Write([]byte("<html><body></body></html>"))
+		return
+	}
+	if strings.Contains(lemmaID, "/") || !s.lemmas[lemmaID] {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(s.config.Dir, lemmaID+pageExt))
+}
+
+func (s *Server) handleSpellcheck(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	name := queryToLemmaID(query)
+	if !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..") {
+		page := filepath.Join(s.config.Dir, spellcheckDir, name+pageExt)
+		if _, err := os.Stat(page); err == nil {
+			http.ServeFile(w, r, page)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := suggestionsTemplate.Execute(w, map[string]interface{}{
+		"Query":       query,
+		"Suggestions": s.suggest(query),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// suggest returns known words that are closest to query
+func (s *Server) suggest(query string) []string {
+	target := queryToLemmaID(query)
+	type candidate struct {
+		word     string
+		distance int
+	}
+	candidates := make([]candidate, 0, len(s.lemmas))
+	for lemmaID := range s.lemmas {
+		candidates = append(candidates, candidate{
+			word:     strings.ReplaceAll(lemmaID, "-", " "),
+			distance: levenshtein(target, lemmaID),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].word < candidates[j].word
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+	suggestions := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		suggestions = append(suggestions, c.word)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// suggestionsTemplate has the same structure that parser.ParseSuggestionHTML expects
+var suggestionsTemplate = template.Must(template.New("spellcheck").Parse(`<html>
+<body>
+<div class="hfl-s lt2b lmt-10 lmb-25 lp-s_r-20">
+<h1 class="tw-bw dhw dpos-h_hw lmb-10">Search suggestions for <span class="q">{{.Query}}</span></h1>
+<ul class="hul-u">
+{{range .Suggestions}}<li class="lbt lp-5 lpl-20"><a href="#"><span class="base">{{.}}</span></a></li>
+{{end}}</ul>
+</div>
+</body>
+</html>
+`))
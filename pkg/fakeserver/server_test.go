@@ -0,0 +1,113 @@
+package fakeserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/querier"
+	"github.com/darkclainer/camgo/pkg/transport"
+)
+
+const fixturesDir = "../parser/testdata/html"
+
+func newTestQuerier(t *testing.T, config *Config) (*querier.Querier, func()) {
+	server, err := New(config)
+	if err != nil {
+		t.Fatalf("can not create fake server: %v", err)
+	}
+	httpServer := httptest.NewServer(server)
+	q := querier.NewQuerier(nil, nil, &querier.Config{
+		Host:     httpServer.Listener.Addr().String(),
+		Protocol: "http",
+	})
+	return q, func() {
+		_ = q.Close(context.TODO())
+		httpServer.Close()
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+	q, clean := newTestQuerier(t, &Config{Dir: fixturesDir})
+	defer clean()
+
+	t.Run("found", func(t *testing.T) {
+		result, err := q.Lookup(context.TODO(), " Get  out ")
+		assert.NoError(t, err)
+		assert.Equal(t, "get-out", result.LemmaID)
+		assert.NotEmpty(t, result.Lemmas)
+	})
+	t.Run("suggestions", func(t *testing.T) {
+		result, err := q.Lookup(context.TODO(), "prnt")
+		assert.True(t, errors.Is(err, querier.ErrSuggestions), "unexpected error: %v", err)
+		if assert.NotEmpty(t, result.Suggestions) {
+			assert.Equal(t, "print", result.Suggestions[0])
+		}
+	})
+	t.Run("empty query", func(t *testing.T) {
+		_, err := q.Lookup(context.TODO(), " ")
+		assert.True(t, errors.Is(err, querier.ErrEmptyLemmaID), "unexpected error: %v", err)
+	})
+	t.Run("unknown lemma", func(t *testing.T) {
+		_, err := q.GetLemma(context.TODO(), "unknown")
+		assert.Error(t, err)
+	})
+}
+
+func TestServerFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camgo-fixtures")
+	if err != nil {
+		t.Fatalf("can not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	page, err := ioutil.ReadFile(filepath.Join(fixturesDir, "print.html"))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "print.html"), page, 0o644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "aliases.json"), []byte(`{"printed": "print"}`), 0o644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, spellcheckDir), 0o755))
+	spellcheck := `<html><body><h1>Suggestions</h1><ul class="hul-u"><li>custom</li></ul></body></html>`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, spellcheckDir, "prnt.html"), []byte(spellcheck), 0o644))
+
+	q, clean := newTestQuerier(t, &Config{Dir: dir})
+	defer clean()
+
+	lemmaID, _, err := q.Search(context.TODO(), "printed")
+	assert.NoError(t, err)
+	assert.Equal(t, "print", lemmaID)
+
+	_, suggestions, err := q.Search(context.TODO(), "prnt")
+	assert.True(t, errors.Is(err, querier.ErrSuggestions))
+	assert.Equal(t, []string{"custom"}, suggestions)
+}
+
+func TestServerMisbehavior(t *testing.T) {
+	t.Run("errors", func(t *testing.T) {
+		server, err := New(&Config{Dir: fixturesDir, ErrorRate: 1, ErrorCode: http.StatusTooManyRequests})
+		if err != nil {
+			t.Fatalf("can not create fake server: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dictionary/english/print", nil))
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	})
+	t.Run("latency", func(t *testing.T) {
+		q, clean := newTestQuerier(t, &Config{Dir: fixturesDir, Latency: transport.ConstantLatency(time.Second)})
+		defer clean()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err := q.Lookup(ctx, "print")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	})
+	t.Run("no fixtures", func(t *testing.T) {
+		_, err := New(&Config{Dir: "testdata/not-exists"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,28 @@
+// Package lookup contains result of high-level dictionary lookup.
+// It is kept apart from querier, so mocks of querier interfaces can use it without import cycles.
+package lookup
+
+import (
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+// Result is result of search of query and getting lemmas for found lemmaID
+type Result struct {
+	// LemmaID is resolved lemmaID, it's empty if nothing was found
+	LemmaID string
+	Lemmas  []*parser.Lemma
+	// Suggestions is filled instead of LemmaID if query was not found
+	Suggestions []string
+	// Cached specifies that the whole result was taken from cache
+	Cached  bool
+	Timings Timings
+}
+
+// Timings specifies how long each step of lookup took
+type Timings struct {
+	Search   time.Duration
+	GetLemma time.Duration
+	Total    time.Duration
+}
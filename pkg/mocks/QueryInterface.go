@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 
+	lookup "github.com/darkclainer/camgo/pkg/lookup"
+
 	parser "github.com/darkclainer/camgo/pkg/parser"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -51,6 +53,29 @@ func (_m *QueryInterface) GetLemma(ctx context.Context, lemmaID string) ([]*pars
 	return r0, r1
 }
 
+// Lookup provides a mock function with given fields: ctx, query
+func (_m *QueryInterface) Lookup(ctx context.Context, query string) (*lookup.Result, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 *lookup.Result
+	if rf, ok := ret.Get(0).(func(context.Context, string) *lookup.Result); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lookup.Result)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Search provides a mock function with given fields: ctx, query
 func (_m *QueryInterface) Search(ctx context.Context, query string) (string, []string, error) {
 	ret := _m.Called(ctx, query)
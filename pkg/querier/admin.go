@@ -0,0 +1,294 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+const recordTypePage = "page"
+
+// RecordInfo describes stored record without its content
+type RecordInfo struct {
+	// Type is "query", "lemma" or "page"
+	Type      string
+	Key       string
+	CreatedAt time.Time
+	// ExpiresAt is zero if record never expires
+	ExpiresAt time.Time
+	// Error is stored error of query or lemma
+	Error string
+	// Size is size of encoded record
+	Size int
+	// DecodeErr is error of decoding record, other fields except Type, Key, ExpiresAt and Size are empty then
+	DecodeErr error
+	// NewerSchema is true if record is written by newer version sharing the cache, DecodeErr is ErrNewerSchema then.
+	// Such records are not broken and are not selected as errors
+	NewerSchema bool
+}
+
+// broken reports if record can't be decoded by any version
+func (i *RecordInfo) broken() bool {
+	return i.DecodeErr != nil && !i.NewerSchema
+}
+
+// RecordFilter selects records, zero value selects every record
+type RecordFilter struct {
+	// Types are "query", "lemma" or "page", empty means every type
+	Types []string
+	// Prefix of query, lemmaID or page
+	Prefix string
+	// Errors selects only records with stored error or broken records that can't be decoded.
+	// Records with newer schema are not selected
+	Errors bool
+	// WithoutErrors selects only records without stored error that can be decoded
+	WithoutErrors bool
+	// OlderThan selects only records created more than OlderThan ago
+	OlderThan time.Duration
+}
+
+func (f *RecordFilter) keyTypes() ([]keyType, error) {
+	if len(f.Types) == 0 {
+		return []keyType{queryKey, lemmaKey, pageKey}, nil
+	}
+	keyTypes := make([]keyType, 0, len(f.Types))
+	for _, name := range f.Types {
+		t, err := parseRecordType(name)
+		if err != nil {
+			return nil, err
+		}
+		keyTypes = append(keyTypes, t)
+	}
+	return keyTypes, nil
+}
+
+func (f *RecordFilter) match(info *RecordInfo) bool {
+	if f.Errors && info.Error == "" && !info.broken() {
+		return false
+	}
+	if f.WithoutErrors && (info.Error != "" || info.DecodeErr != nil) {
+		return false
+	}
+	if f.OlderThan > 0 && (info.DecodeErr != nil || time.Since(info.CreatedAt) <= f.OlderThan) {
+		return false
+	}
+	return true
+}
+
+// List calls fn for every record selected by filter. Error of fn stops listing
+func (s *Storage) List(filter *RecordFilter, fn func(*RecordInfo) error) error {
+	keyTypes, err := filter.keyTypes()
+	if err != nil {
+		return err
+	}
+	for _, t := range keyTypes {
+		t := t
+		prefix := marshalKey(filter.Prefix, t)
+		err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+			info := s.recordInfo(t, entry)
+			if !filter.match(info) {
+				return nil
+			}
+			return fn(info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) recordInfo(t keyType, entry *store.Entry) *RecordInfo {
+	info := &RecordInfo{
+		Type:      recordTypeName(t),
+		Key:       string(entry.Key[1:]),
+		ExpiresAt: entry.ExpiresAt,
+		Size:      len(entry.Key) + len(entry.Value),
+	}
+	switch t {
+	case queryKey:
+		query, _, err := decodeQuery(entry.Value, s.migrator())
+		if err != nil {
+			info.DecodeErr, info.NewerSchema = err, errors.Is(err, ErrNewerSchema)
+			return info
+		}
+		info.CreatedAt, info.Error = query.CreatedAt, query.Error
+	case lemmaKey:
+		lemma, _, err := decodeLemma(entry.Value, s.migrator())
+		if err != nil {
+			info.DecodeErr, info.NewerSchema = err, errors.Is(err, ErrNewerSchema)
+			return info
+		}
+		info.CreatedAt, info.Error = lemma.CreatedAt, lemma.Error
+	case pageKey:
+		var page CachedPage
+		if err := json.Unmarshal(entry.Value, &page); err != nil {
+			info.DecodeErr = err
+			return info
+		}
+		info.CreatedAt = page.FetchedAt
+	}
+	return info
+}
+
+// Purge deletes records selected by filter and returns their number. If dryRun is true, nothing is deleted
+func (s *Storage) Purge(filter *RecordFilter, dryRun bool) (int, error) {
+	var keys [][]byte
+	err := s.List(filter, func(info *RecordInfo) error {
+		t, _ := parseRecordType(info.Type)
+		keys = append(keys, marshalKey(info.Key, t))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(keys), nil
+	}
+	for i, key := range keys {
+		if err := s.deleteEntry(key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// DefaultAgeBuckets are upper bounds of ages in CacheSummary
+var DefaultAgeBuckets = []time.Duration{
+	time.Hour,
+	time.Hour * 24,
+	time.Hour * 24 * 7,
+	time.Hour * 24 * 30,
+	time.Hour * 24 * 365,
+}
+
+// TypeSummary is summary of records of one type
+type TypeSummary struct {
+	Records int
+	// Errors is number of records with stored error
+	Errors int
+	// Broken is number of records that can't be decoded
+	Broken int
+	// Newer is number of records with newer schema, they are not counted in Errors, Broken and Ages
+	Newer int
+	// Bytes is total size of encoded records
+	Bytes int64
+	// Ages[i] is number of records younger than AgeBuckets[i], the last one is number of older records
+	Ages []int
+}
+
+// CacheSummary is summary of stored records
+type CacheSummary struct {
+	// Types are summaries by record type: "query", "lemma" and "page"
+	Types      map[string]*TypeSummary
+	AgeBuckets []time.Duration
+	// DiskSize is size of store, it's -1 if store doesn't report it
+	DiskSize int64
+}
+
+// Summary counts every record. ageBuckets are ascending upper bounds of ages, nil means DefaultAgeBuckets
+func (s *Storage) Summary(ageBuckets []time.Duration) (*CacheSummary, error) {
+	if ageBuckets == nil {
+		ageBuckets = DefaultAgeBuckets
+	}
+	summary := &CacheSummary{
+		Types:      make(map[string]*TypeSummary),
+		AgeBuckets: ageBuckets,
+	}
+	for _, t := range []keyType{queryKey, lemmaKey, pageKey} {
+		summary.Types[recordTypeName(t)] = &TypeSummary{Ages: make([]int, len(ageBuckets)+1)}
+	}
+	err := s.List(&RecordFilter{}, func(info *RecordInfo) error {
+		typeSummary := summary.Types[info.Type]
+		typeSummary.Records++
+		typeSummary.Bytes += int64(info.Size)
+		if info.NewerSchema {
+			typeSummary.Newer++
+			return nil
+		}
+		if info.DecodeErr != nil {
+			typeSummary.Broken++
+			return nil
+		}
+		if info.Error != "" {
+			typeSummary.Errors++
+		}
+		age := time.Since(info.CreatedAt)
+		bucket := 0
+		for bucket < len(ageBuckets) && age >= ageBuckets[bucket] {
+			bucket++
+		}
+		typeSummary.Ages[bucket]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if summary.DiskSize, err = s.DiskSize(); err != nil {
+		return nil, fmt.Errorf("can not get size of store: %w", err)
+	}
+	return summary, nil
+}
+
+// ErrGCUnsupported is returned by CollectGarbage if store can't collect garbage
+var ErrGCUnsupported = errors.New("store doesn't support garbage collection")
+
+// CollectGarbage reclaims space of deleted and expired records if store supports it.
+// Zero discardRatio means store.DefaultDiscardRatio
+func (s *Storage) CollectGarbage(discardRatio float64) error {
+	collector, ok := s.Store.(store.Collector)
+	if !ok {
+		return ErrGCUnsupported
+	}
+	return collector.CollectGarbage(discardRatio)
+}
+
+// ErrFlattenUnsupported is returned by Flatten if store has no LSM tree
+var ErrFlattenUnsupported = errors.New("store doesn't support flatten")
+
+// Flatten compacts LSM tree of store if store supports it
+func (s *Storage) Flatten() error {
+	flattener, ok := s.Store.(store.Flattener)
+	if !ok {
+		return ErrFlattenUnsupported
+	}
+	return flattener.Flatten()
+}
+
+// DiskSize returns size of store or -1 if store doesn't report it
+func (s *Storage) DiskSize() (int64, error) {
+	sizer, ok := s.Store.(store.Sizer)
+	if !ok {
+		return -1, nil
+	}
+	return sizer.Size()
+}
+
+// ParseRecordKey parses key in form "type:key", like "lemma:hello"
+func ParseRecordKey(s string) (recordType, key string, err error) {
+	parts := strings.SplitN(s, ":", 2) // nolint:gomnd // type and key
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("key '%s' should be in form type:key", s)
+	}
+	if _, err := parseRecordType(parts[0]); err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+func parseRecordType(name string) (keyType, error) {
+	switch name {
+	case recordTypeQuery:
+		return queryKey, nil
+	case recordTypeLemma:
+		return lemmaKey, nil
+	case recordTypePage:
+		return pageKey, nil
+	default:
+		return 0, fmt.Errorf("unknown record type '%s'", name)
+	}
+}
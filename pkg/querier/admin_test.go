@@ -0,0 +1,139 @@
+package querier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func newAdminStorage(t *testing.T) *Storage {
+	storage := &Storage{Store: store.NewMemory(0)}
+	assert.NoError(t, storage.PutQuery("hello", "hello", nil, nil))
+	assert.NoError(t, storage.PutQuery("helo", "", []string{"hello"}, ErrSuggestions))
+	assert.NoError(t, storage.PutQuery("help", "", nil, &StatusError{StatusCode: 503}))
+	assert.NoError(t, storage.PutLemma("hello", []*parser.Lemma{{Lemma: "hello"}}, nil))
+	putAgedLemma(t, storage, "old", []*parser.Lemma{{Lemma: "old"}}, time.Hour*48)
+	assert.NoError(t, storage.PutPage("hello", &Page{URL: "url", FetchedAt: time.Now(), Body: []byte("<html></html>")}))
+	assert.NoError(t, storage.Store.Put(marshalKey("broken", lemmaKey), []byte("broken"), 0))
+	return storage
+}
+
+func listKeys(t *testing.T, storage *Storage, filter *RecordFilter) []string {
+	var keys []string
+	err := storage.List(filter, func(info *RecordInfo) error {
+		keys = append(keys, info.Type+":"+info.Key)
+		return nil
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestStorageList(t *testing.T) {
+	storage := newAdminStorage(t)
+	testCases := map[string]struct {
+		filter   *RecordFilter
+		expected []string
+	}{
+		"all": {
+			filter: &RecordFilter{},
+			expected: []string{
+				"query:hello", "query:helo", "query:help",
+				"lemma:broken", "lemma:hello", "lemma:old",
+				"page:hello",
+			},
+		},
+		"prefix": {
+			filter:   &RecordFilter{Prefix: "hel", Types: []string{"query"}},
+			expected: []string{"query:hello", "query:helo", "query:help"},
+		},
+		"errors": {
+			filter:   &RecordFilter{Errors: true},
+			expected: []string{"query:helo", "query:help", "lemma:broken"},
+		},
+		"older than": {
+			filter:   &RecordFilter{OlderThan: time.Hour * 24},
+			expected: []string{"lemma:old"},
+		},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, listKeys(t, storage, tc.filter))
+		})
+	}
+	err := storage.List(&RecordFilter{Types: []string{"unknown"}}, func(*RecordInfo) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestStoragePurge(t *testing.T) {
+	storage := newAdminStorage(t)
+	filter := &RecordFilter{Errors: true, Types: []string{"query"}}
+	purged, err := storage.Purge(filter, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Len(t, listKeys(t, storage, filter), 2, "dry run doesn't delete records")
+
+	purged, err = storage.Purge(filter, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Empty(t, listKeys(t, storage, filter))
+	assert.Equal(t, []string{"query:hello"}, listKeys(t, storage, &RecordFilter{Types: []string{"query"}}))
+}
+
+func TestStorageSummary(t *testing.T) {
+	storage := newAdminStorage(t)
+	summary, err := storage.Summary([]time.Duration{time.Hour * 24})
+	assert.NoError(t, err)
+	queries := summary.Types[recordTypeQuery]
+	assert.Equal(t, 3, queries.Records)
+	assert.Equal(t, 2, queries.Errors)
+	assert.Equal(t, []int{3, 0}, queries.Ages)
+	lemmas := summary.Types[recordTypeLemma]
+	assert.Equal(t, 3, lemmas.Records)
+	assert.Equal(t, 1, lemmas.Broken)
+	assert.Equal(t, []int{1, 1}, lemmas.Ages)
+	assert.Equal(t, 1, summary.Types[recordTypePage].Records)
+	assert.True(t, summary.DiskSize > 0)
+
+	assert.NoError(t, storage.CollectGarbage(0))
+	assert.Equal(t, ErrFlattenUnsupported, storage.Flatten())
+}
+
+func TestStorageNewerSchemaRecords(t *testing.T) {
+	storage := &Storage{Store: store.NewMemory(0)}
+	newer := &Storage{Store: storage.Store, Migrator: newTestMigrator(t, upperLemmaMigration)}
+	assert.NoError(t, newer.PutLemma("newer", []*parser.Lemma{{Lemma: "newer"}}, nil))
+	assert.NoError(t, storage.Store.Put(marshalKey("broken", lemmaKey), []byte("broken"), 0))
+
+	// records of newer version are not errors, so they are not purged with errors
+	errorsFilter := &RecordFilter{Errors: true}
+	assert.Equal(t, []string{"lemma:broken"}, listKeys(t, storage, errorsFilter))
+	purged, err := storage.Purge(errorsFilter, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = newer.GetLemma("newer")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.Store.Put(marshalKey("broken", lemmaKey), []byte("broken"), 0))
+	summary, err := storage.Summary(nil)
+	assert.NoError(t, err)
+	lemmas := summary.Types[recordTypeLemma]
+	assert.Equal(t, 2, lemmas.Records)
+	assert.Equal(t, 1, lemmas.Broken)
+	assert.Equal(t, 1, lemmas.Newer)
+}
+
+func TestParseRecordKey(t *testing.T) {
+	recordType, key, err := ParseRecordKey("query:to begin: with")
+	assert.NoError(t, err)
+	assert.Equal(t, "query", recordType)
+	assert.Equal(t, "to begin: with", key)
+	_, _, err = ParseRecordKey("hello")
+	assert.Error(t, err)
+	_, _, err = ParseRecordKey("word:hello")
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package querier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// aliasPrefix is prefix of alias records of queries that point at lemmaID
+func aliasPrefix(lemmaID string) []byte {
+	return append(marshalKey(lemmaID, aliasKey), 0)
+}
+
+func aliasRecordKey(lemmaID, query string) []byte {
+	return append(aliasPrefix(lemmaID), query...)
+}
+
+// queryLemmaID returns lemmaID of stored query, it's empty if query is missing or has no lemmaID
+func (s *Storage) queryLemmaID(query string) string {
+	entry, err := s.Store.Get(marshalKey(query, queryKey))
+	if err != nil {
+		return ""
+	}
+	cached, _, err := decodeQuery(entry.Value, s.migrator())
+	if err != nil || cached.Error != "" {
+		return ""
+	}
+	return cached.LemmaID
+}
+
+// linkQuery adds to batch alias record of query that points at lemmaID and removal of the previous one
+func (s *Storage) linkQuery(batch *writeBatch, query, previous, lemmaID string, ttl time.Duration) {
+	if previous != "" && previous != lemmaID {
+		batch.delete(aliasRecordKey(previous, query))
+	}
+	if lemmaID != "" {
+		batch.put(aliasRecordKey(lemmaID, query), nil, ttl)
+	}
+}
+
+// Aliases returns stored queries that point at lemmaID
+func (s *Storage) Aliases(lemmaID string) ([]string, error) {
+	var queries []string
+	prefix := aliasPrefix(lemmaID)
+	err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+		query := string(entry.Key[len(prefix):])
+		if s.queryLemmaID(query) == lemmaID {
+			queries = append(queries, query)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not iterate aliases: %w", err)
+	}
+	return queries, nil
+}
+
+// invalidateAliases removes queries that point at lemmaID, so they are searched again
+func (s *Storage) invalidateAliases(lemmaID string) error {
+	var keys [][]byte
+	err := s.Store.Iterate(aliasPrefix(lemmaID), func(entry *store.Entry) error {
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can not iterate aliases: %w", err)
+	}
+	prefixLength := len(aliasPrefix(lemmaID))
+	for _, key := range keys {
+		query := string(key[prefixLength:])
+		if s.queryLemmaID(query) == lemmaID {
+			// it removes alias record too
+			if err := s.deleteEntry(marshalKey(query, queryKey)); err != nil {
+				return err
+			}
+			continue
+		}
+		// query was stored again with another lemmaID
+		if err := s.Store.Delete(key); err != nil {
+			return fmt.Errorf("can not delete alias: %w", err)
+		}
+	}
+	return nil
+}
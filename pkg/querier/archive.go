@@ -0,0 +1,172 @@
+package querier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// CachedPage is archived raw page of lemma, so lemma can be reparsed without network
+type CachedPage struct {
+	URL       string
+	FetchedAt time.Time
+	// Body is gzip compressed html
+	Body []byte
+}
+
+// HTML returns decompressed page
+func (cp *CachedPage) HTML() ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(cp.Body))
+	if err != nil {
+		return nil, fmt.Errorf("can not decompress page: %w", err)
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func (s *Storage) PutPage(lemmaID string, page *Page) error {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(page.Body); err != nil {
+		return fmt.Errorf("can not compress page: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("can not compress page: %w", err)
+	}
+	value := CachedPage{
+		URL:       page.URL,
+		FetchedAt: page.FetchedAt,
+		Body:      compressed.Bytes(),
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.putEntry(marshalKey(lemmaID, pageKey), data, 0)
+}
+
+func (s *Storage) GetPage(lemmaID string) (*CachedPage, error) {
+	key := marshalKey(lemmaID, pageKey)
+	var pageValue CachedPage
+	if err := s.getValue(key, &pageValue); err != nil {
+		return nil, err
+	}
+	return &pageValue, nil
+}
+
+// ReparseResult describes what happened with lemma during reparse
+type ReparseResult struct {
+	LemmaID string
+	// Changed is true if parsed lemmas differ from stored ones
+	Changed bool
+	// Err is error of parsing or storing lemma
+	Err error
+}
+
+// Reparse parses every archived page with p and replaces stored lemmas with new result.
+// Creation time of replaced lemmas is set to fetch time of page. They keep their expiration time,
+// unless success becomes error or vice versa, then errors expire after 24 hours and successes never expire.
+// If dryRun is true, storage is not changed. report is called for every archived page.
+func (s *Storage) Reparse(p Parser, dryRun bool, report func(*ReparseResult)) error {
+	lemmaIDs, err := s.archivedLemmaIDs()
+	if err != nil {
+		return err
+	}
+	for _, lemmaID := range lemmaIDs {
+		report(s.reparseLemma(p, lemmaID, dryRun))
+	}
+	return nil
+}
+
+func (s *Storage) reparseLemma(p Parser, lemmaID string, dryRun bool) *ReparseResult {
+	result := &ReparseResult{LemmaID: lemmaID}
+	page, err := s.GetPage(lemmaID)
+	if err != nil {
+		result.Err = fmt.Errorf("can not get page: %w", err)
+		return result
+	}
+	html, err := page.HTML()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	lemmas, parseErr := p.ParseLemma(bytes.NewReader(html))
+	var errString string
+	if parseErr != nil {
+		errString = parseErr.Error()
+	}
+	// reparsed lemma is as old as its page. It expires when the old lemma would, if both are successes
+	// or both are errors, otherwise ttl is chosen by result, so errors expire and successes don't
+	createdAt, ttl := page.FetchedAt, defaultTTL(parseErr)
+	old, err := s.GetLemma(lemmaID)
+	switch {
+	case err == nil:
+		result.Changed = old.Error != errString || !sameLemmas(old.Lemmas, lemmas)
+		if createdAt.IsZero() {
+			createdAt = old.CreatedAt
+		}
+		if (old.Error == "") != (parseErr == nil) {
+			break
+		}
+		ttl, err = s.lemmaTTL(lemmaID)
+		if err != nil {
+			result.Err = fmt.Errorf("can not get lemma: %w", err)
+			return result
+		}
+	case errors.Is(err, store.ErrNotFound):
+		result.Changed = true
+	default:
+		result.Err = fmt.Errorf("can not get lemma: %w", err)
+		return result
+	}
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if result.Changed && !dryRun {
+		if err := s.putLemma(lemmaID, lemmas, parseErr, createdAt, ttl); err != nil {
+			result.Err = fmt.Errorf("can not put lemma: %w", err)
+		}
+	}
+	return result
+}
+
+// lemmaTTL returns remaining ttl of stored lemma, zero means that lemma never expires
+func (s *Storage) lemmaTTL(lemmaID string) (time.Duration, error) {
+	entry, err := s.Store.Get(marshalKey(lemmaID, lemmaKey))
+	if err != nil {
+		return 0, err
+	}
+	ttl, alive := remainingTTL(entry)
+	if !alive {
+		return 0, store.ErrNotFound
+	}
+	return ttl, nil
+}
+
+// archivedLemmaIDs returns lemmaIDs of every archived page
+func (s *Storage) archivedLemmaIDs() ([]string, error) {
+	prefix := []byte{byte(pageKey)}
+	var lemmaIDs []string
+	err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+		lemmaIDs = append(lemmaIDs, string(entry.Key[len(prefix):]))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not list archived pages: %w", err)
+	}
+	return lemmaIDs, nil
+}
+
+// sameLemmas compares lemmas as they are stored, so nil and empty fields are equal
+func sameLemmas(a, b []*parser.Lemma) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,124 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+func TestPage(t *testing.T) {
+	storage := getStorage(t)
+	page := &Page{
+		URL:       "http://example.com/dictionary/english/hello",
+		FetchedAt: time.Now().Round(0),
+		Body:      []byte("<html>hello</html>"),
+	}
+	assert.NoError(t, storage.PutPage("hello", page))
+	cached, err := storage.GetPage("hello")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, page.URL, cached.URL)
+	assert.True(t, page.FetchedAt.Equal(cached.FetchedAt))
+	html, err := cached.HTML()
+	assert.NoError(t, err)
+	assert.Equal(t, page.Body, html)
+}
+
+func TestReparse(t *testing.T) {
+	storage := getStorage(t)
+	newLemmas := []*parser.Lemma{{Lemma: "new"}}
+	newBody, err := json.Marshal(newLemmas)
+	if err != nil {
+		t.Fatalf("can not marshal lemmas: %v", err)
+	}
+	// lemma that parser now parses differently
+	fetchedAt := time.Now().Add(-time.Hour).Round(0)
+	assert.NoError(t, storage.PutPage("changed", &Page{Body: newBody, FetchedAt: fetchedAt}))
+	assert.NoError(t, storage.PutLemmaWithTTL("changed", []*parser.Lemma{{Lemma: "old"}}, nil, time.Hour))
+	oldEntry, err := storage.Store.Get(marshalKey("changed", lemmaKey))
+	if !assert.NoError(t, err) {
+		return
+	}
+	// lemma that is parsed the same way
+	assert.NoError(t, storage.PutPage("same", &Page{Body: newBody}))
+	assert.NoError(t, storage.PutLemma("same", newLemmas, nil))
+	// lemma that now can't be parsed
+	assert.NoError(t, storage.PutPage("broken", &Page{Body: []byte("{,}")}))
+	assert.NoError(t, storage.PutLemma("broken", newLemmas, nil))
+	// error that now can be parsed
+	assert.NoError(t, storage.PutPage("fixed", &Page{Body: newBody}))
+	assert.NoError(t, storage.PutLemma("fixed", nil, errors.New("can not parse")))
+
+	reparse := func(dryRun bool) map[string]bool {
+		changed := make(map[string]bool)
+		err := storage.Reparse(&JSONParser{}, dryRun, func(result *ReparseResult) {
+			assert.NoError(t, result.Err)
+			changed[result.LemmaID] = result.Changed
+		})
+		assert.NoError(t, err)
+		return changed
+	}
+	expected := map[string]bool{"changed": true, "same": false, "broken": true, "fixed": true}
+	assert.Equal(t, expected, reparse(true))
+	// dry run didn't change anything, so real run reports the same
+	assert.Equal(t, expected, reparse(false))
+	assert.Equal(t, map[string]bool{"changed": false, "same": false, "broken": false, "fixed": false}, reparse(false))
+
+	cached, err := storage.GetLemma("changed")
+	assert.NoError(t, err)
+	assert.Equal(t, newLemmas, cached.Lemmas)
+	assert.True(t, fetchedAt.Equal(cached.CreatedAt), "reparsed lemma must be as old as its page")
+	entry, err := storage.Store.Get(marshalKey("changed", lemmaKey))
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, oldEntry.ExpiresAt, entry.ExpiresAt, time.Second, "reparse must keep expiration time")
+	}
+	cached, err = storage.GetLemma("broken")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cached.Error)
+	// new error expires like any other error
+	entry, err = storage.Store.Get(marshalKey("broken", lemmaKey))
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, time.Now().Add(ttlForErros), entry.ExpiresAt, time.Minute)
+	}
+	// fixed lemma doesn't expire with the old error
+	cached, err = storage.GetLemma("fixed")
+	assert.NoError(t, err)
+	assert.Equal(t, newLemmas, cached.Lemmas)
+	entry, err = storage.Store.Get(marshalKey("fixed", lemmaKey))
+	if assert.NoError(t, err) {
+		assert.True(t, entry.ExpiresAt.IsZero(), "fixed lemma must not expire")
+	}
+}
+
+func TestCachedArchivePages(t *testing.T) {
+	storage := getStorage(t)
+	lemmaFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`[{"lemma": "hello"}]`))
+		},
+	}
+	querier, clean := newTestQuerier(t, nil, nil, lemmaFn)
+	defer clean()
+
+	cached := NewCached(querier, storage.Store, &CachedConfig{ArchivePages: true})
+	lemmas, err := cached.GetLemma(context.TODO(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []*parser.Lemma{{Lemma: "hello"}}, lemmas)
+
+	page, err := storage.GetPage("hello")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, querier.newLemmaURL("hello"), page.URL)
+	html, err := page.HTML()
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"lemma": "hello"}]`, string(html))
+}
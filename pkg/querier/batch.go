@@ -0,0 +1,117 @@
+package querier
+
+import (
+	"context"
+	"sync"
+)
+
+// LookupOptions specifies how LookupMany processes queries
+type LookupOptions struct {
+	// Concurrency specifies how many queries are looked up at the same time.
+	// Zero value means one
+	Concurrency int
+	// Ordered specifies that results are delivered in the order of queries.
+	// Otherwise they are delivered in the order of completion
+	Ordered bool
+	// Priority specifies priority of parsing of pages.
+	// Zero value is PriorityBatch, so interactive lookups are not delayed
+	Priority Priority
+}
+
+// LookupManyResult is result of looking up one of queries passed to LookupMany
+type LookupManyResult struct {
+	LookupResult
+	// Index is index of query in queries passed to LookupMany
+	Index int
+	Query string
+	Err   error
+}
+
+// LookupMany looks up every query.
+// Queries share rate limiter and parse pool of querier.
+func (q *Querier) LookupMany(ctx context.Context, queries []string, opts *LookupOptions) <-chan *LookupManyResult {
+	return lookupMany(ctx, q, queries, opts)
+}
+
+// LookupMany looks up every query through cache.
+func (c *Cached) LookupMany(ctx context.Context, queries []string, opts *LookupOptions) <-chan *LookupManyResult {
+	return lookupMany(ctx, c, queries, opts)
+}
+
+// lookupMany streams results of lookup of every query to returned channel.
+// Channel is closed after result for every query was sent, so it must be drained.
+// If ctx is done, remaining queries are not sent to q and their results have ctx error.
+func lookupMany(ctx context.Context, q QueryInterface, queries []string, opts *LookupOptions) <-chan *LookupManyResult {
+	if opts == nil {
+		opts = &LookupOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx = WithPriority(ctx, opts.Priority)
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range queries {
+			jobs <- i
+		}
+	}()
+
+	completed := make(chan *LookupManyResult)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				completed <- lookupOne(ctx, q, index, queries[index])
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(completed)
+	}()
+
+	if !opts.Ordered {
+		return completed
+	}
+	results := make(chan *LookupManyResult)
+	go func() {
+		defer close(results)
+		pending := make(map[int]*LookupManyResult)
+		next := 0
+		for result := range completed {
+			pending[result.Index] = result
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				results <- ready
+				next++
+			}
+		}
+	}()
+	return results
+}
+
+func lookupOne(ctx context.Context, q QueryInterface, index int, query string) *LookupManyResult {
+	result := &LookupManyResult{
+		Index: index,
+		Query: query,
+	}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+	lookupResult, err := q.Lookup(ctx, query)
+	if lookupResult != nil {
+		result.LookupResult = *lookupResult
+	}
+	result.Err = err
+	return result
+}
@@ -0,0 +1,124 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+func TestLookupMany(t *testing.T) {
+	queries := []string{"a", "b", "c", "d", "e", "f"}
+	newQuerier := func() *mocks.QueryInterface {
+		q := &mocks.QueryInterface{}
+		for i, query := range queries {
+			// later queries are faster, so completion order differs from input order
+			delay := time.Millisecond * time.Duration(len(queries)-i) * 5
+			q.On("Lookup", mock.Anything, query).
+				After(delay).
+				Return(&LookupResult{
+					LemmaID: "id_" + query,
+					Lemmas:  []*parser.Lemma{{Lemma: query}},
+				}, nil)
+		}
+		return q
+	}
+	t.Run("ordered", func(t *testing.T) {
+		q := newQuerier()
+		var got []string
+		for result := range lookupMany(context.TODO(), q, queries, &LookupOptions{Concurrency: 3, Ordered: true}) {
+			assert.NoError(t, result.Err)
+			assert.Equal(t, queries[result.Index], result.Query)
+			assert.Equal(t, "id_"+result.Query, result.LemmaID)
+			assert.Equal(t, []*parser.Lemma{{Lemma: result.Query}}, result.Lemmas)
+			got = append(got, result.Query)
+		}
+		assert.Equal(t, queries, got)
+		q.AssertExpectations(t)
+	})
+	t.Run("completion order", func(t *testing.T) {
+		q := newQuerier()
+		var got []string
+		for result := range lookupMany(context.TODO(), q, queries, &LookupOptions{Concurrency: len(queries)}) {
+			assert.NoError(t, result.Err)
+			got = append(got, result.Query)
+		}
+		assert.ElementsMatch(t, queries, got)
+		assert.NotEqual(t, queries, got)
+	})
+}
+
+func TestLookupManyErrors(t *testing.T) {
+	q := &mocks.QueryInterface{}
+	q.On("Lookup", mock.Anything, "helo").
+		Return(&LookupResult{Suggestions: []string{"hello"}}, ErrSuggestions)
+	q.On("Lookup", mock.Anything, "broken").
+		Return(nil, errors.New("broken lemma"))
+
+	queries := []string{"helo", "broken"}
+	var results []*LookupManyResult
+	for result := range lookupMany(context.TODO(), q, queries, &LookupOptions{Ordered: true}) {
+		results = append(results, result)
+	}
+	if !assert.Len(t, results, len(queries)) {
+		return
+	}
+	assert.True(t, errors.Is(results[0].Err, ErrSuggestions))
+	assert.Equal(t, []string{"hello"}, results[0].Suggestions)
+	assert.EqualError(t, results[1].Err, "broken lemma")
+	q.AssertExpectations(t)
+}
+
+func TestLookupManyConcurrency(t *testing.T) {
+	const concurrency = 3
+	var mu sync.Mutex
+	var active, maxActive int
+	q := &mocks.QueryInterface{}
+	q.On("Lookup", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 10)
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}).
+		Return(&LookupResult{}, nil)
+
+	var queries []string
+	for i := 0; i < concurrency*4; i++ {
+		queries = append(queries, fmt.Sprintf("query%d", i))
+	}
+	count := 0
+	for range lookupMany(context.TODO(), q, queries, &LookupOptions{Concurrency: concurrency}) {
+		count++
+	}
+	assert.Equal(t, len(queries), count)
+	assert.Equal(t, concurrency, maxActive)
+}
+
+func TestLookupManyCancel(t *testing.T) {
+	q := &mocks.QueryInterface{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queries := []string{"a", "b"}
+	count := 0
+	for result := range lookupMany(ctx, q, queries, nil) {
+		assert.Equal(t, context.Canceled, result.Err)
+		count++
+	}
+	assert.Equal(t, len(queries), count)
+	q.AssertNotCalled(t, "Lookup", mock.Anything, mock.Anything)
+}
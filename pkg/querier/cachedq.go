@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-
-	"github.com/dgraph-io/badger/v2"
+	"sync"
+	"time"
 
 	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+	"github.com/darkclainer/camgo/pkg/transport"
 )
 
 //go:generate go run github.com/vektra/mockery/cmd/mockery -name QueryInterface -output ../mocks/
@@ -16,53 +18,284 @@ import (
 type QueryInterface interface {
 	GetLemma(ctx context.Context, lemmaID string) ([]*parser.Lemma, error)
 	Search(ctx context.Context, query string) (string, []string, error)
+	Lookup(ctx context.Context, query string) (*LookupResult, error)
 	Close(ctx context.Context) error
 }
 
+// pageQuerier is implemented by queriers that can return raw pages of lemmas, like Querier
+type pageQuerier interface {
+	GetLemmaPage(ctx context.Context, lemmaID string) ([]*parser.Lemma, *Page, error)
+}
+
+type CachedConfig struct {
+	// ArchivePages specifies that raw pages of lemmas are stored next to parsed lemmas,
+	// so they can be reparsed later with Storage.Reparse. Querier must support it.
+	ArchivePages bool
+	// Freshness specifies when cached records are fetched again.
+	// By default successful records never expire and errors expire after 24 hours
+	Freshness *FreshnessPolicy
+	// Memory enables in-process cache of decoded records in front of storage.
+	// Lemmas returned by Cached are shared with the cache then, so they must not be modified.
+	Memory *MemoryCacheConfig
+	// Encoding of written records, by default they are written as JSON
+	Encoding *RecordEncoding
+	// Maintenance enables periodic garbage collection of store until Cached is closed
+	Maintenance *MaintenanceConfig
+	// Normalization is applied to queries before they are searched and cached, nil means raw queries
+	Normalization *QueryNormalization
+	// Snapshot is read-only base layer under storage, like cache built by Storage.Snapshot.
+	// Records missing in storage are read from snapshot, new records are written only to storage.
+	// Snapshot is closed with Cached
+	Snapshot store.CacheStore
+	// Peers enables sharing of records with other instances, records owned by another peer are asked from it
+	// before they are fetched from dictionary. Raw pages are archived only by owners
+	Peers *PeerConfig
+}
+
 type Cached struct {
 	querier QueryInterface
 	storage *Storage
+	config  *CachedConfig
+	// peers is nil if peering is disabled
+	peers *peerPool
+
+	lemmaFlights flightGroup
+	queryFlights flightGroup
+
+	// background refreshes of stale records and maintenance are cancelled and waited for on Close
+	background       sync.WaitGroup
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+
+	maintenanceMu sync.Mutex
 }
 
-func NewCached(querier QueryInterface, storage *badger.DB) *Cached {
-	return &Cached{
-		querier: querier,
-		storage: &Storage{DB: storage},
+// NewCached returns querier that caches results in storage. config can be nil
+func NewCached(querier QueryInterface, storage store.CacheStore, config *CachedConfig) *Cached {
+	if config == nil {
+		config = &CachedConfig{}
 	}
+	if config.Snapshot != nil {
+		storage = store.NewLayered(storage, config.Snapshot)
+	}
+	backgroundCtx, cancelBackground := context.WithCancel(context.Background())
+	cachedStorage := NewStorage(storage, config.Memory)
+	cachedStorage.Encoding = config.Encoding
+	c := &Cached{
+		querier:          querier,
+		storage:          cachedStorage,
+		config:           config,
+		backgroundCtx:    WithPriority(backgroundCtx, PriorityBatch),
+		cancelBackground: cancelBackground,
+	}
+	if config.Peers != nil {
+		c.peers = newPeerPool(config.Peers)
+	}
+	if config.Maintenance != nil {
+		c.startMaintenance(config.Maintenance)
+	}
+	return c
 }
 
+// GetLemma returns lemmas from storage or fetches and stores them.
+// Concurrent misses with the same lemmaID share one fetch and its result, so returned lemmas must not be modified.
 func (c *Cached) GetLemma(ctx context.Context, lemmaID string) ([]*parser.Lemma, error) {
-	cached, err := c.storage.GetLemma(lemmaID)
-	if err == nil {
-		return cached.Return()
+	lemmas, _, err := c.getLemma(ctx, lemmaID)
+	return lemmas, err
+}
+
+func (c *Cached) getLemma(ctx context.Context, lemmaID string) (lemmas []*parser.Lemma, hit bool, err error) {
+	// forced refresh or refresh of expired record must not replace it with transient error
+	refresh := refreshRequested(ctx)
+	if !refresh {
+		cached, err := c.storage.GetLemma(lemmaID)
+		switch {
+		case err == nil:
+			state := fresh
+			if cached.Error == "" {
+				state = c.config.Freshness.check(lemmaKey, cached.CreatedAt)
+			}
+			if state == stale {
+				c.refreshInBackground(func(ctx context.Context) {
+					_, _ = c.loadLemma(ctx, lemmaID, true)
+				})
+			}
+			if state != expired {
+				lemmas, err = cached.Return()
+				return lemmas, true, err
+			}
+			refresh = true
+		case !isMissingRecord(err):
+			return nil, false, err
+		}
 	}
-	if !errors.Is(err, badger.ErrKeyNotFound) {
+	result, err := c.loadLemma(ctx, lemmaID, refresh)
+	if err != nil {
+		return nil, false, err
+	}
+	return result.lemmas, false, result.err
+}
+
+// loadLemma fetches lemmas and stores them. Concurrent callers with the same lemmaID
+// share one request and one write to storage. If refresh is true, transient errors
+// are not stored, so they don't replace existing lemmas.
+func (c *Cached) loadLemma(ctx context.Context, lemmaID string, refresh bool) (*lemmaResult, error) {
+	value, err := c.lemmaFlights.Do(ctx, lemmaID, func(ctx context.Context) interface{} {
+		lemmas, page, err := c.fetchLemma(ctx, lemmaID)
+		if isContextError(err) || isOverloadError(err) {
+			// request was abandoned or rejected locally, it says nothing about lemma
+			return &lemmaResult{lemmas: lemmas, err: err}
+		}
+		if page != nil {
+			if dbErr := c.storage.PutPage(lemmaID, page); dbErr != nil { // nolint:staticcheck // todo
+				// TODO: log this event
+			}
+		}
+		if ttl, ok := c.recordTTL(err, refresh); ok && !c.storage.hasNewerRecord(marshalKey(lemmaID, lemmaKey)) {
+			if dbErr := c.storage.PutLemmaWithTTL(lemmaID, lemmas, err, ttl); dbErr != nil { // nolint:staticcheck // todo
+				// TODO: log this event
+			}
+		}
+		return &lemmaResult{lemmas: lemmas, err: err}
+	})
+	if err != nil {
 		return nil, err
 	}
-	lemmas, err := c.querier.GetLemma(ctx, lemmaID)
-	if dbErr := c.storage.PutLemma(lemmaID, lemmas, err); dbErr != nil { // nolint:staticcheck // todo
-		// TODO: log this event
+	return value.(*lemmaResult), nil
+}
+
+// fetchLemma gets lemmas from owner peer or from querier with their page if pages are archived
+func (c *Cached) fetchLemma(ctx context.Context, lemmaID string) ([]*parser.Lemma, *Page, error) {
+	if lemmas, ok, err := c.peers.getLemma(ctx, lemmaID); ok {
+		return lemmas, nil, err
 	}
-	return lemmas, err
+	if pq, ok := c.querier.(pageQuerier); ok && c.config.ArchivePages {
+		return pq.GetLemmaPage(ctx, lemmaID)
+	}
+	lemmas, err := c.querier.GetLemma(ctx, lemmaID)
+	return lemmas, nil, err
 }
 
+// Search returns stored result of query or searches and stores it.
+// Concurrent misses with the same query share one search and its result, so suggestions must not be modified.
 func (c *Cached) Search(ctx context.Context, query string) (lemmaID string, suggestions []string, err error) {
-	cached, err := c.storage.GetQuery(query)
-	if err == nil {
-		return cached.Return()
+	lemmaID, suggestions, _, err = c.search(ctx, query)
+	return lemmaID, suggestions, err
+}
+
+func (c *Cached) search(ctx context.Context, query string) (lemmaID string, suggestions []string, hit bool, err error) {
+	query = c.config.Normalization.Normalize(query)
+	refresh := refreshRequested(ctx)
+	if !refresh {
+		cached, err := c.storage.GetQuery(query)
+		switch {
+		case err == nil:
+			state := fresh
+			if cached.Error == "" {
+				state = c.config.Freshness.check(queryKey, cached.CreatedAt)
+			}
+			if state == stale {
+				c.refreshInBackground(func(ctx context.Context) {
+					_, _ = c.loadQuery(ctx, query, true)
+				})
+			}
+			if state != expired {
+				lemmaID, suggestions, err = cached.Return()
+				return lemmaID, suggestions, true, err
+			}
+			refresh = true
+		case !isMissingRecord(err):
+			return "", nil, false, err
+		}
 	}
-	if !errors.Is(err, badger.ErrKeyNotFound) {
-		return "", nil, err
+	result, err := c.loadQuery(ctx, query, refresh)
+	if err != nil {
+		return "", nil, false, err
 	}
-	// err is ErrKeyNotFound
-	lemmaID, suggestions, err = c.querier.Search(ctx, query)
-	if dbErr := c.storage.PutQuery(query, lemmaID, suggestions, err); dbErr != nil { // nolint:staticcheck // todo
-		// TODO: log this event
+	return result.lemmaID, result.suggestions, false, result.err
+}
+
+// loadQuery searches query and stores result the same way as loadLemma
+func (c *Cached) loadQuery(ctx context.Context, query string, refresh bool) (*searchResult, error) {
+	value, err := c.queryFlights.Do(ctx, query, func(ctx context.Context) interface{} {
+		lemmaID, suggestions, err := c.fetchQuery(ctx, query)
+		if isContextError(err) || isOverloadError(err) {
+			return &searchResult{lemmaID: lemmaID, suggestions: suggestions, err: err}
+		}
+		if ttl, ok := c.recordTTL(err, refresh); ok && !c.storage.hasNewerRecord(marshalKey(query, queryKey)) {
+			if dbErr := c.storage.PutQueryWithTTL(query, lemmaID, suggestions, err, ttl); dbErr != nil { // nolint:staticcheck // todo
+				// TODO: log this event
+			}
+		}
+		return &searchResult{lemmaID: lemmaID, suggestions: suggestions, err: err}
+	})
+	if err != nil {
+		return nil, err
 	}
-	return lemmaID, suggestions, err
+	return value.(*searchResult), nil
+}
+
+// fetchQuery searches query through owner peer or through querier
+func (c *Cached) fetchQuery(ctx context.Context, query string) (lemmaID string, suggestions []string, err error) {
+	if lemmaID, suggestions, ok, err := c.peers.search(ctx, query); ok {
+		return lemmaID, suggestions, err
+	}
+	return c.querier.Search(ctx, query)
+}
+
+// recordTTL returns ttl of record with err and reports if record should be stored at all
+func (c *Cached) recordTTL(err error, refresh bool) (time.Duration, bool) {
+	if err == nil {
+		return 0, true
+	}
+	if refresh && ClassifyError(err).isTransient() {
+		// keep stale record instead of temporary failure
+		return 0, false
+	}
+	ttl := c.config.Freshness.errorTTL(err)
+	return ttl, ttl >= 0
+}
+
+// refreshInBackground runs fn in background until Cached is closed
+func (c *Cached) refreshInBackground(fn func(ctx context.Context)) {
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		fn(c.backgroundCtx)
+	}()
+}
+
+// Lookup searches query and gets lemmas for found lemmaID through cache.
+// Result is marked as cached only if both search and lemmas were taken from storage.
+func (c *Cached) Lookup(ctx context.Context, query string) (*LookupResult, error) {
+	return lookupWith(ctx, query, c.search, c.getLemma)
+}
+
+// Stats returns hit and miss counters of memory and store tiers
+func (c *Cached) Stats() CacheStats {
+	return c.storage.Stats()
+}
+
+// isMissingRecord reports if record should be fetched again, because it's not stored
+// or it can't be upgraded to current schema. Fetched record doesn't replace record with newer schema
+func isMissingRecord(err error) bool {
+	var migrationErr *MigrationError
+	return errors.Is(err, store.ErrNotFound) || errors.As(err, &migrationErr)
+}
+
+// isContextError reports if err is caused by cancellation or deadline of context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isOverloadError reports if err is back-pressure of local parse scheduler or rate limiter
+func isOverloadError(err error) bool {
+	return errors.Is(err, ErrQueueFull) || errors.Is(err, ErrSchedulerStopped) || errors.Is(err, transport.ErrRateLimitWait)
 }
 
 func (c *Cached) Close(ctx context.Context) error {
+	c.cancelBackground()
+	c.background.Wait()
 	var errs []error
 	if closeErr := c.querier.Close(ctx); closeErr != nil {
 		errs = append(errs, fmt.Errorf("querier close failed: %w", closeErr))
@@ -3,14 +3,21 @@ package querier
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/dgraph-io/badger/v2"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/time/rate"
 
 	"github.com/darkclainer/camgo/pkg/mocks"
 	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+	"github.com/darkclainer/camgo/pkg/transport"
 )
 
 func TestCachedGetLemma(t *testing.T) {
@@ -24,7 +31,7 @@ func TestCachedGetLemma(t *testing.T) {
 		q := &mocks.QueryInterface{}
 		q.On("GetLemma", mock.Anything, "test_lemma").
 			Return(expectedLemmas, errors.New("test error"))
-		cached := NewCached(q, storage.DB)
+		cached := NewCached(q, storage.Store, nil)
 
 		lemmas, err := cached.GetLemma(context.TODO(), "test_lemma")
 		q.AssertExpectations(t)
@@ -33,7 +40,7 @@ func TestCachedGetLemma(t *testing.T) {
 	})
 	t.Run("get through storage", func(t *testing.T) {
 		q := &mocks.QueryInterface{}
-		cached := NewCached(q, storage.DB)
+		cached := NewCached(q, storage.Store, nil)
 		lemmas, err := cached.GetLemma(context.TODO(), "test_lemma")
 		assert.EqualError(t, err, "test error")
 		assert.Equal(t, expectedLemmas, lemmas)
@@ -55,7 +62,7 @@ func TestCachedSearch(t *testing.T) {
 		q := &mocks.QueryInterface{}
 		q.On("Search", mock.Anything, "test_query").
 			Return(expected.id, expected.suggestions, expected.err)
-		cached := NewCached(q, storage.DB)
+		cached := NewCached(q, storage.Store, nil)
 
 		id, suggestions, err := cached.Search(context.TODO(), "test_query")
 		q.AssertExpectations(t)
@@ -65,7 +72,7 @@ func TestCachedSearch(t *testing.T) {
 	})
 	t.Run("get through cached", func(t *testing.T) {
 		q := &mocks.QueryInterface{}
-		cached := NewCached(q, storage.DB)
+		cached := NewCached(q, storage.Store, nil)
 
 		id, suggestions, err := cached.Search(context.TODO(), "test_query")
 		q.AssertExpectations(t)
@@ -75,15 +82,249 @@ func TestCachedSearch(t *testing.T) {
 	})
 }
 
-func TestCachedClose(t *testing.T) {
-	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+func TestCachedLookup(t *testing.T) {
+	storage := getStorage(t)
+	expectedLemmas := []*parser.Lemma{
+		{
+			Lemma: "hello",
+		},
+	}
+	t.Run("through querier", func(t *testing.T) {
+		q := &mocks.QueryInterface{}
+		q.On("Search", mock.Anything, "hello").
+			Return("hello_id", []string(nil), nil)
+		q.On("GetLemma", mock.Anything, "hello_id").
+			Return(expectedLemmas, nil)
+		cached := NewCached(q, storage.Store, nil)
+
+		result, err := cached.Lookup(context.TODO(), "hello")
+		q.AssertExpectations(t)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello_id", result.LemmaID)
+		assert.Equal(t, expectedLemmas, result.Lemmas)
+		assert.False(t, result.Cached)
+	})
+	t.Run("through storage", func(t *testing.T) {
+		q := &mocks.QueryInterface{}
+		cached := NewCached(q, storage.Store, nil)
+
+		result, err := cached.Lookup(context.TODO(), "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello_id", result.LemmaID)
+		assert.Equal(t, expectedLemmas, result.Lemmas)
+		assert.True(t, result.Cached)
+	})
+	t.Run("suggestions through storage", func(t *testing.T) {
+		q := &mocks.QueryInterface{}
+		q.On("Search", mock.Anything, "helo").
+			Return("", []string{"hello"}, ErrSuggestions).
+			Once()
+		cached := NewCached(q, storage.Store, nil)
+
+		for i := 0; i < 2; i++ {
+			result, err := cached.Lookup(context.TODO(), "helo")
+			assert.True(t, errors.Is(err, ErrSuggestions))
+			assert.Equal(t, []string{"hello"}, result.Suggestions)
+			assert.Equal(t, i > 0, result.Cached)
+		}
+		q.AssertExpectations(t)
+	})
+}
+
+func TestCachedQueueFull(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), nil)
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "busy").
+		Return("", []string(nil), fmt.Errorf("can not get suggestions: %w", ErrQueueFull)).Once()
+	q.On("GetLemma", mock.Anything, "busy_id").
+		Return([]*parser.Lemma(nil), fmt.Errorf("failed to schedule parse: %w", ErrSchedulerStopped)).Once()
+	cached := NewCached(q, storage.Store, nil)
+
+	_, _, err := cached.Search(context.TODO(), "busy")
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	_, err = cached.GetLemma(context.TODO(), "busy_id")
+	assert.True(t, errors.Is(err, ErrSchedulerStopped))
+	q.AssertExpectations(t)
+
+	// local back-pressure is not cached, so the next call tries again
+	_, err = storage.GetQuery("busy")
+	assert.True(t, isMissingRecord(err))
+	_, err = storage.GetLemma("busy_id")
+	assert.True(t, isMissingRecord(err))
+}
+
+func TestCachedRateLimitWait(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), nil)
+	querier, clean := newTestQuerier(t, nil, nil, nil)
+	defer clean()
+	// requests have short deadline, like requests of caller with timeout
+	deadline := func(next http.RoundTripper) http.RoundTripper {
+		return transport.RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			ctx, cancel := context.WithTimeout(request.Context(), time.Millisecond*100)
+			defer cancel()
+			return next.RoundTrip(request.WithContext(ctx))
+		})
+	}
+	// the only token is taken, the next one will be available only after a second
+	limiter := rate.NewLimiter(1, 1)
+	assert.True(t, limiter.Allow())
+	querier.client = wrapClient(querier.client, &Config{Middlewares: []transport.Middleware{deadline}}, limiter)
+	cached := NewCached(querier, storage.Store, nil)
+
+	_, err := cached.GetLemma(context.TODO(), "hello")
+	assert.True(t, errors.Is(err, transport.ErrRateLimitWait), "unexpected error: %v", err)
+	_, _, err = cached.Search(context.TODO(), "hello")
+	assert.True(t, errors.Is(err, transport.ErrRateLimitWait), "unexpected error: %v", err)
+
+	// slow local limiter says nothing about records, so they are not cached
+	_, err = storage.GetLemma("hello")
+	assert.True(t, isMissingRecord(err))
+	_, err = storage.GetQuery("hello")
+	assert.True(t, isMissingRecord(err))
+}
+
+func TestCachedSharedRedis(t *testing.T) {
+	server, err := miniredis.Run()
 	if err != nil {
-		t.Fatalf("can not open badger: %v", err)
+		t.Fatalf("can not run redis: %v", err)
+	}
+	defer server.Close()
+	newCached := func(q QueryInterface) *Cached {
+		cache, err := store.OpenRedis("redis://"+server.Addr(), "camgo:")
+		if err != nil {
+			t.Fatalf("can not open redis: %v", err)
+		}
+		return NewCached(q, cache, &CachedConfig{Encoding: &RecordEncoding{Binary: true}})
+	}
+	expectedLemmas := []*parser.Lemma{{Lemma: "hello"}}
+	first := &mocks.QueryInterface{}
+	first.On("Search", mock.Anything, "hello").Return("hello_id", []string(nil), nil).Once()
+	first.On("GetLemma", mock.Anything, "hello_id").Return(expectedLemmas, nil).Once()
+	first.On("Search", mock.Anything, "helo").Return("", []string{"hello"}, ErrSuggestions).Once()
+	firstCached := newCached(first)
+	_, err = firstCached.Lookup(context.TODO(), "hello")
+	assert.NoError(t, err)
+	_, err = firstCached.Lookup(context.TODO(), "helo")
+	assert.True(t, errors.Is(err, ErrSuggestions))
+	first.AssertExpectations(t)
+
+	// records written by one process are read by another one
+	second := &mocks.QueryInterface{}
+	secondCached := newCached(second)
+	result, err := secondCached.Lookup(context.TODO(), "hello")
+	assert.NoError(t, err)
+	assert.True(t, result.Cached)
+	assert.Equal(t, expectedLemmas, result.Lemmas)
+	result, err = secondCached.Lookup(context.TODO(), "helo")
+	assert.True(t, errors.Is(err, ErrSuggestions))
+	assert.True(t, result.Cached)
+	second.AssertExpectations(t)
+
+	// errors expire in redis itself, successful records never expire
+	assert.Equal(t, time.Duration(0), server.TTL("camgo:"+string(marshalKey("hello", queryKey))))
+	assert.Equal(t, ttlForErros, server.TTL("camgo:"+string(marshalKey("helo", queryKey))))
+}
+
+func TestCachedCoalescing(t *testing.T) {
+	storage := getStorage(t)
+	const callers = 10
+	t.Run("get lemma", func(t *testing.T) {
+		release := make(chan time.Time)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "shared_lemma").
+			WaitUntil(release).
+			Return([]*parser.Lemma{{Lemma: "shared"}}, errors.New("shared error")).
+			Once()
+		cached := NewCached(q, storage.Store, nil)
+		joined := joinedFlights(&cached.lemmaFlights, callers)
+
+		var wg sync.WaitGroup
+		wg.Add(callers)
+		for i := 0; i < callers; i++ {
+			go func() {
+				defer wg.Done()
+				lemmas, err := cached.GetLemma(context.TODO(), "shared_lemma")
+				assert.EqualError(t, err, "shared error")
+				assert.Len(t, lemmas, 1)
+			}()
+		}
+		waitJoined(joined, callers)
+		close(release)
+		wg.Wait()
+		q.AssertExpectations(t)
+	})
+	t.Run("search", func(t *testing.T) {
+		release := make(chan time.Time)
+		q := &mocks.QueryInterface{}
+		q.On("Search", mock.Anything, "shared_query").
+			WaitUntil(release).
+			Return("shared_lemma", []string(nil), errors.New("shared error")).
+			Once()
+		cached := NewCached(q, storage.Store, nil)
+		joined := joinedFlights(&cached.queryFlights, callers)
+
+		var wg sync.WaitGroup
+		wg.Add(callers)
+		for i := 0; i < callers; i++ {
+			go func() {
+				defer wg.Done()
+				lemmaID, _, err := cached.Search(context.TODO(), "shared_query")
+				assert.EqualError(t, err, "shared error")
+				assert.Equal(t, "shared_lemma", lemmaID)
+			}()
+		}
+		waitJoined(joined, callers)
+		close(release)
+		wg.Wait()
+		q.AssertExpectations(t)
+	})
+	t.Run("caller cancellation", func(t *testing.T) {
+		release := make(chan time.Time)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "slow_lemma").
+			WaitUntil(release).
+			Return([]*parser.Lemma(nil), errors.New("slow error")).
+			Once()
+		cached := NewCached(q, storage.Store, nil)
+		joined := joinedFlights(&cached.lemmaFlights, 2)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := cached.GetLemma(context.TODO(), "slow_lemma")
+			assert.EqualError(t, err, "slow error")
+		}()
+		waitJoined(joined, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := cached.GetLemma(ctx, "slow_lemma")
+		assert.Equal(t, context.DeadlineExceeded, err)
+		close(release)
+		<-done
+		q.AssertExpectations(t)
+	})
+}
+
+// joinedFlights returns channel that receives key of every caller that joins flight of group
+func joinedFlights(group *flightGroup, callers int) <-chan string {
+	joined := make(chan string, callers)
+	group.joined = func(key string) {
+		joined <- key
+	}
+	return joined
+}
+
+func waitJoined(joined <-chan string, callers int) {
+	for i := 0; i < callers; i++ {
+		<-joined
 	}
+}
+
+func TestCachedClose(t *testing.T) {
 	t.Run("fine", func(t *testing.T) {
 		q := &mocks.QueryInterface{}
 		q.On("Close", mock.Anything).Return(nil)
-		cached := NewCached(q, db)
+		cached := NewCached(q, store.NewMemory(0), nil)
 
 		err := cached.Close(context.TODO())
 		q.AssertExpectations(t)
@@ -92,7 +333,7 @@ func TestCachedClose(t *testing.T) {
 	t.Run("error in querier", func(t *testing.T) {
 		q := &mocks.QueryInterface{}
 		q.On("Close", mock.Anything).Return(errors.New("test err"))
-		cached := NewCached(q, db)
+		cached := NewCached(q, store.NewMemory(0), nil)
 
 		err := cached.Close(context.TODO())
 		q.AssertExpectations(t)
@@ -0,0 +1,383 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+// Compression of binary records
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+	compressionCount
+)
+
+// RecordEncoding specifies how queries and lemmas are written to storage.
+// Records are read in every encoding regardless of it.
+type RecordEncoding struct {
+	// Binary enables msgpack encoding, otherwise records are written as JSON
+	Binary      bool
+	Compression Compression
+}
+
+// ParseRecordEncoding parses encoding in form "json", "msgpack", "msgpack+snappy" or "msgpack+zstd"
+func ParseRecordEncoding(s string) (*RecordEncoding, error) {
+	switch s {
+	case "json":
+		return &RecordEncoding{}, nil
+	case "msgpack":
+		return &RecordEncoding{Binary: true}, nil
+	case "msgpack+snappy":
+		return &RecordEncoding{Binary: true, Compression: CompressionSnappy}, nil
+	case "msgpack+zstd":
+		return &RecordEncoding{Binary: true, Compression: CompressionZstd}, nil
+	default:
+		return nil, fmt.Errorf("unknown record encoding '%s'", s)
+	}
+}
+
+// Binary records start with header byte: format version in high 4 bits and compression in low 4 bits.
+// JSON records start with '{', so format version 7 is never used.
+// Layouts of formats are frozen, change of schema of records needs new format.
+const (
+	// formatMsgpackV1 has records of schema 0 without schema version
+	formatMsgpackV1 byte = 1
+	// formatMsgpackV2 has schema version as the first field
+	formatMsgpackV2 byte = 2
+
+	jsonRecordStart byte = '{'
+	compressionMask byte = 0x0f
+	formatShift          = 4
+)
+
+var (
+	ErrUnknownEncoding = errors.New("unknown encoding of record")
+	errEmptyRecord     = errors.New("record is empty")
+)
+
+// queryRecordV1 is layout of CachedQuery in formatMsgpackV1. Fields are stored as array,
+// so they must never be reordered, new format version is needed instead.
+type queryRecordV1 struct {
+	_msgpack    struct{} `msgpack:",asArray"` // nolint:structcheck,unused // used by msgpack
+	LemmaID     string
+	Suggestions []string
+	Error       string
+	CreatedAt   time.Time
+}
+
+// queryRecordV2 is layout of CachedQuery in formatMsgpackV2.
+// JSON tags are the same as keys of JSON record of the same schema.
+type queryRecordV2 struct {
+	_msgpack      struct{} `msgpack:",asArray"` // nolint:structcheck,unused // used by msgpack
+	SchemaVersion int
+	LemmaID       string
+	Suggestions   []string
+	Error         string
+	CreatedAt     time.Time
+}
+
+// lemmaRecordV1 is layout of CachedLemma in formatMsgpackV1
+type lemmaRecordV1 struct {
+	_msgpack  struct{} `msgpack:",asArray"` // nolint:structcheck,unused // used by msgpack
+	Lemmas    []*lemmaV1
+	Error     string
+	CreatedAt time.Time
+}
+
+// lemmaRecordV2 is layout of CachedLemma in formatMsgpackV2
+type lemmaRecordV2 struct {
+	_msgpack      struct{} `msgpack:",asArray"` // nolint:structcheck,unused // used by msgpack
+	SchemaVersion int
+	Lemmas        []*lemmaV1
+	Error         string
+	CreatedAt     time.Time
+}
+
+// lemmaV1 is layout of parser.Lemma in formatMsgpackV1 and formatMsgpackV2
+type lemmaV1 struct {
+	_msgpack       struct{}            `msgpack:",asArray"` // nolint:structcheck,unused // used by msgpack
+	Lemma          string              `json:"lemma"`
+	PartOfSpeech   []string            `json:"part_of_speech,omitempty"`
+	Language       string              `json:"language"`
+	Transcriptions map[string][]string `json:"transcriptions,omitempty"`
+	Definition     string              `json:"definition"`
+	GuideWord      string              `json:"guide_word"`
+	Alternative    string              `json:"alternative"`
+	Grammar        []string            `json:"grammar,omitempty"`
+	Examples       []string            `json:"examples,omitempty"`
+}
+
+func encodeQuery(value *CachedQuery, encoding *RecordEncoding) ([]byte, error) {
+	if encoding == nil || !encoding.Binary {
+		return json.Marshal(value)
+	}
+	return encodeBinary(&queryRecordV2{
+		SchemaVersion: value.SchemaVersion,
+		LemmaID:       value.LemmaID,
+		Suggestions:   value.Suggestions,
+		Error:         value.Error,
+		CreatedAt:     value.CreatedAt,
+	}, encoding.Compression)
+}
+
+// decodeQuery decodes query and upgrades it to schema of m. It returns schema version of stored record
+func decodeQuery(data []byte, m *Migrator) (*CachedQuery, int, error) {
+	var value CachedQuery
+	if isJSONRecord(data) {
+		version, err := decodeJSONRecord(data, queryKey, m, &value)
+		return &value, version, err
+	}
+	format, payload, err := readBinary(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var record queryRecordV2
+	switch format {
+	case formatMsgpackV1:
+		var old queryRecordV1
+		if err := unmarshalBinary(payload, &old); err != nil {
+			return nil, 0, err
+		}
+		record = queryRecordV2{
+			LemmaID:     old.LemmaID,
+			Suggestions: old.Suggestions,
+			Error:       old.Error,
+			CreatedAt:   old.CreatedAt,
+		}
+	case formatMsgpackV2:
+		if err := unmarshalBinary(payload, &record); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, ErrUnknownEncoding
+	}
+	if record.SchemaVersion != m.version {
+		return &value, record.SchemaVersion, upgradeDocument(&record, record.SchemaVersion, queryKey, m, &value)
+	}
+	value.SchemaVersion = record.SchemaVersion
+	value.LemmaID = record.LemmaID
+	value.Suggestions = record.Suggestions
+	value.Error = record.Error
+	value.CreatedAt = record.CreatedAt
+	return &value, record.SchemaVersion, nil
+}
+
+func encodeLemma(value *CachedLemma, encoding *RecordEncoding) ([]byte, error) {
+	if encoding == nil || !encoding.Binary {
+		return json.Marshal(value)
+	}
+	record := &lemmaRecordV2{
+		SchemaVersion: value.SchemaVersion,
+		Error:         value.Error,
+		CreatedAt:     value.CreatedAt,
+	}
+	if value.Lemmas != nil {
+		record.Lemmas = make([]*lemmaV1, len(value.Lemmas))
+		for i, lemma := range value.Lemmas {
+			record.Lemmas[i] = &lemmaV1{
+				Lemma:          lemma.Lemma,
+				PartOfSpeech:   lemma.PartOfSpeech,
+				Language:       lemma.Language,
+				Transcriptions: lemma.Transcriptions,
+				Definition:     lemma.Definition,
+				GuideWord:      lemma.GuideWord,
+				Alternative:    lemma.Alternative,
+				Grammar:        lemma.Grammar,
+				Examples:       lemma.Examples,
+			}
+		}
+	}
+	return encodeBinary(record, encoding.Compression)
+}
+
+// decodeLemma decodes lemma and upgrades it to schema of m. It returns schema version of stored record
+func decodeLemma(data []byte, m *Migrator) (*CachedLemma, int, error) {
+	var value CachedLemma
+	if isJSONRecord(data) {
+		version, err := decodeJSONRecord(data, lemmaKey, m, &value)
+		return &value, version, err
+	}
+	format, payload, err := readBinary(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var record lemmaRecordV2
+	switch format {
+	case formatMsgpackV1:
+		var old lemmaRecordV1
+		if err := unmarshalBinary(payload, &old); err != nil {
+			return nil, 0, err
+		}
+		record = lemmaRecordV2{
+			Lemmas:    old.Lemmas,
+			Error:     old.Error,
+			CreatedAt: old.CreatedAt,
+		}
+	case formatMsgpackV2:
+		if err := unmarshalBinary(payload, &record); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, ErrUnknownEncoding
+	}
+	if record.SchemaVersion != m.version {
+		return &value, record.SchemaVersion, upgradeDocument(&record, record.SchemaVersion, lemmaKey, m, &value)
+	}
+	value.SchemaVersion = record.SchemaVersion
+	value.Error = record.Error
+	value.CreatedAt = record.CreatedAt
+	if record.Lemmas != nil {
+		value.Lemmas = make([]*parser.Lemma, len(record.Lemmas))
+		for i, lemma := range record.Lemmas {
+			value.Lemmas[i] = &parser.Lemma{
+				Lemma:          lemma.Lemma,
+				PartOfSpeech:   lemma.PartOfSpeech,
+				Language:       lemma.Language,
+				Transcriptions: lemma.Transcriptions,
+				Definition:     lemma.Definition,
+				GuideWord:      lemma.GuideWord,
+				Alternative:    lemma.Alternative,
+				Grammar:        lemma.Grammar,
+				Examples:       lemma.Examples,
+			}
+		}
+	}
+	return &value, record.SchemaVersion, nil
+}
+
+func isJSONRecord(data []byte) bool {
+	return len(data) != 0 && data[0] == jsonRecordStart
+}
+
+// decodeJSONRecord decodes JSON record into value, if record has older schema, it's upgraded first
+func decodeJSONRecord(data []byte, t keyType, m *Migrator, value interface{}) (int, error) {
+	var header struct {
+		SchemaVersion int
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	if header.SchemaVersion == m.version {
+		return header.SchemaVersion, json.Unmarshal(data, value)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return 0, err
+	}
+	return header.SchemaVersion, upgradeDocument(doc, header.SchemaVersion, t, m, value)
+}
+
+// upgradeDocument converts record to JSON document, upgrades it with m and decodes it into value
+func upgradeDocument(record interface{}, version int, t keyType, m *Migrator, value interface{}) error {
+	doc, ok := record.(map[string]interface{})
+	if !ok {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	}
+	if err := m.upgrade(t, doc, version); err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func encodeBinary(record interface{}, compression Compression) ([]byte, error) {
+	if compression >= compressionCount {
+		return nil, ErrUnknownEncoding
+	}
+	encoded, err := msgpack.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	header := formatMsgpackV2<<formatShift | byte(compression)
+	switch compression {
+	case CompressionSnappy:
+		encoded = snappy.Encode(nil, encoded)
+	case CompressionZstd:
+		encoded = zstdEncoder().EncodeAll(encoded, nil)
+	}
+	return append([]byte{header}, encoded...), nil
+}
+
+// readBinary returns format and decompressed payload of binary record
+func readBinary(data []byte) (format byte, payload []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, errEmptyRecord
+	}
+	header, payload := data[0], data[1:]
+	format = header >> formatShift
+	if format != formatMsgpackV1 && format != formatMsgpackV2 {
+		return 0, nil, ErrUnknownEncoding
+	}
+	switch Compression(header & compressionMask) {
+	case CompressionNone:
+	case CompressionSnappy:
+		payload, err = snappy.Decode(nil, payload)
+	case CompressionZstd:
+		payload, err = zstdDecoder().DecodeAll(payload, nil)
+	default:
+		return 0, nil, ErrUnknownEncoding
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("can not decompress record: %w", err)
+	}
+	return format, payload, nil
+}
+
+func unmarshalBinary(payload []byte, record interface{}) error {
+	if err := msgpack.Unmarshal(payload, record); err != nil {
+		return fmt.Errorf("can not decode record: %w", err)
+	}
+	return nil
+}
+
+// zstd encoder and decoder are safe for concurrent EncodeAll and DecodeAll, so they are shared
+var zstdCodec struct {
+	init    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func initZstd() {
+	zstdCodec.init.Do(func() {
+		var err error
+		zstdCodec.encoder, err = zstd.NewWriter(nil)
+		if err != nil {
+			panic(fmt.Sprintf("can not create zstd encoder: %v", err))
+		}
+		zstdCodec.decoder, err = zstd.NewReader(nil)
+		if err != nil {
+			panic(fmt.Sprintf("can not create zstd decoder: %v", err))
+		}
+	})
+}
+
+func zstdEncoder() *zstd.Encoder {
+	initZstd()
+	return zstdCodec.encoder
+}
+
+func zstdDecoder() *zstd.Decoder {
+	initZstd()
+	return zstdCodec.decoder
+}
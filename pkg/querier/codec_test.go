@@ -0,0 +1,212 @@
+package querier
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+const fixturesDir = "../parser/testdata/html"
+
+var testEncodings = map[string]*RecordEncoding{
+	"json":           nil,
+	"msgpack":        {Binary: true},
+	"msgpack+snappy": {Binary: true, Compression: CompressionSnappy},
+	"msgpack+zstd":   {Binary: true, Compression: CompressionZstd},
+}
+
+// loadFixtureLemmas parses every fixture page
+func loadFixtureLemmas(tb testing.TB) map[string]*CachedLemma {
+	pages, err := filepath.Glob(filepath.Join(fixturesDir, "*.html"))
+	if err != nil || len(pages) == 0 {
+		tb.Fatalf("can not find fixtures: %v", err)
+	}
+	result := make(map[string]*CachedLemma, len(pages))
+	for _, page := range pages {
+		file, err := os.Open(page)
+		if err != nil {
+			tb.Fatalf("can not open fixture: %v", err)
+		}
+		lemmas, err := (&HTMLParser{}).ParseLemma(file)
+		file.Close()
+		if err != nil {
+			tb.Fatalf("can not parse fixture: %v", err)
+		}
+		result[filepath.Base(page)] = &CachedLemma{
+			Lemmas:        lemmas,
+			CreatedAt:     time.Now().Round(0),
+			SchemaVersion: CurrentSchemaVersion,
+		}
+	}
+	return result
+}
+
+func TestLemmaCodec(t *testing.T) {
+	fixtures := loadFixtureLemmas(t)
+	for name, encoding := range testEncodings {
+		encoding := encoding
+		t.Run(name, func(t *testing.T) {
+			for fixture, lemma := range fixtures {
+				data, err := encodeLemma(lemma, encoding)
+				assert.NoError(t, err)
+				decoded, version, err := decodeLemma(data, defaultMigrator)
+				assert.NoError(t, err)
+				assert.Equal(t, CurrentSchemaVersion, version)
+				assert.Equal(t, lemma.Lemmas, decoded.Lemmas, fixture)
+				assert.True(t, lemma.CreatedAt.Equal(decoded.CreatedAt), fixture)
+			}
+		})
+	}
+}
+
+func TestQueryCodec(t *testing.T) {
+	query := &CachedQuery{
+		Suggestions:   []string{"hello", "help"},
+		Error:         ErrSuggestions.Error(),
+		CreatedAt:     time.Now().Round(0),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	for name, encoding := range testEncodings {
+		encoding := encoding
+		t.Run(name, func(t *testing.T) {
+			data, err := encodeQuery(query, encoding)
+			assert.NoError(t, err)
+			decoded, _, err := decodeQuery(data, defaultMigrator)
+			assert.NoError(t, err)
+			assert.Equal(t, query.Suggestions, decoded.Suggestions)
+			assert.Equal(t, query.Error, decoded.Error)
+			assert.True(t, query.CreatedAt.Equal(decoded.CreatedAt))
+		})
+	}
+}
+
+// TestLemmaLayout fails when parser.Lemma is changed, then new binary format with new layout must be added,
+// because lemmaV1 is layout of already stored records and can't be changed
+func TestLemmaLayout(t *testing.T) {
+	type field struct {
+		Name string
+		Type reflect.Type
+		Tag  reflect.StructTag
+	}
+	fields := func(value interface{}) []field {
+		var result []field
+		typ := reflect.TypeOf(value)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Name == "_msgpack" {
+				continue
+			}
+			result = append(result, field{Name: f.Name, Type: f.Type, Tag: f.Tag})
+		}
+		return result
+	}
+	assert.Equal(t, fields(parser.Lemma{}), fields(lemmaV1{}), "binary layout of lemma differs from parser.Lemma")
+}
+
+func TestDecodeInvalidRecord(t *testing.T) {
+	_, _, err := decodeLemma(nil, defaultMigrator)
+	assert.Error(t, err)
+	_, _, err = decodeLemma([]byte{0x50, 0x01}, defaultMigrator)
+	assert.Equal(t, ErrUnknownEncoding, err)
+	_, _, err = decodeLemma([]byte{formatMsgpackV2<<formatShift | 0x0f}, defaultMigrator)
+	assert.Equal(t, ErrUnknownEncoding, err)
+	_, _, err = decodeLemma([]byte{formatMsgpackV2<<formatShift | byte(CompressionSnappy), 0xff}, defaultMigrator)
+	assert.Error(t, err)
+}
+
+func TestStorageMixedEncodings(t *testing.T) {
+	cache := store.NewMemory(0)
+	legacy := &Storage{Store: cache}
+	fixtures := loadFixtureLemmas(t)
+	for fixture, lemma := range fixtures {
+		assert.NoError(t, legacy.PutLemma(fixture, lemma.Lemmas, nil))
+	}
+	binary := &Storage{Store: cache, Encoding: &RecordEncoding{Binary: true, Compression: CompressionZstd}}
+	assert.NoError(t, binary.PutQuery("query", "lemma", nil, nil))
+	for fixture, lemma := range fixtures {
+		stored, err := binary.GetLemma(fixture)
+		assert.NoError(t, err)
+		assert.Equal(t, lemma.Lemmas, stored.Lemmas)
+	}
+	stored, err := legacy.GetQuery("query")
+	assert.NoError(t, err)
+	assert.Equal(t, "lemma", stored.LemmaID)
+}
+
+func TestParseRecordEncoding(t *testing.T) {
+	for name, expected := range testEncodings {
+		encoding, err := ParseRecordEncoding(name)
+		assert.NoError(t, err)
+		if expected == nil {
+			expected = &RecordEncoding{}
+		}
+		assert.Equal(t, expected, encoding)
+	}
+	_, err := ParseRecordEncoding("xml")
+	assert.Error(t, err)
+}
+
+// BenchmarkLemmaEncoding reports size of encoded fixtures and time of their decoding
+func BenchmarkLemmaEncoding(b *testing.B) {
+	fixtures := loadFixtureLemmas(b)
+	for name, encoding := range testEncodings {
+		encoding := encoding
+		var encoded [][]byte
+		size := 0
+		for _, lemma := range fixtures {
+			data, err := encodeLemma(lemma, encoding)
+			if err != nil {
+				b.Fatalf("can not encode lemma: %v", err)
+			}
+			encoded = append(encoded, data)
+			size += len(data)
+		}
+		b.Run("encode/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, lemma := range fixtures {
+					if _, err := encodeLemma(lemma, encoding); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run("decode/"+name, func(b *testing.B) {
+			b.ReportMetric(float64(size), "bytes")
+			for i := 0; i < b.N; i++ {
+				for _, data := range encoded {
+					if _, _, err := decodeLemma(data, defaultMigrator); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// legacyLemma is how lemmas were stored before encodings were introduced
+func legacyLemma(t *testing.T, lemma *CachedLemma) []byte {
+	data, err := json.Marshal(lemma)
+	if err != nil {
+		t.Fatalf("can not marshal lemma: %v", err)
+	}
+	return data
+}
+
+func TestDecodeLegacyLemma(t *testing.T) {
+	for fixture, lemma := range loadFixtureLemmas(t) {
+		lemma.SchemaVersion = 0
+		decoded, version, err := decodeLemma(legacyLemma(t, lemma), defaultMigrator)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, version)
+		assert.Equal(t, lemma.Lemmas, decoded.Lemmas, fixture)
+		assert.Equal(t, CurrentSchemaVersion, decoded.SchemaVersion)
+	}
+}
@@ -0,0 +1,159 @@
+package querier
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+// flightGroup coalesces concurrent calls with the same key into one execution.
+// Unlike plain singleflight, every caller can leave on its own context, and
+// the shared execution is cancelled only when all of its callers have left.
+// Shared execution has the highest priority of its callers, see WithPriority.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	// joined and finished are hooks for tests, they are called when caller joins call with key
+	// and when call with key has finished
+	joined   func(key string)
+	finished func(key string)
+}
+
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	value   interface{}
+	// priority is the highest priority of callers, it's read by fn through its context
+	priority int32
+}
+
+// raise sets priority of call to priority if it's higher
+func (c *flightCall) raise(priority Priority) {
+	for {
+		current := atomic.LoadInt32(&c.priority)
+		if int32(priority) <= current || atomic.CompareAndSwapInt32(&c.priority, current, int32(priority)) {
+			return
+		}
+	}
+}
+
+// Do calls fn once for all concurrent callers with the same key and returns its result
+// to each of them. If ctx is done before fn returns, Do returns ctx.Err() immediately.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) interface{}) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{
+			done:     make(chan struct{}),
+			priority: int32(priorityFromContext(ctx)),
+		}
+		var flightCtx context.Context
+		flightCtx, call.cancel = context.WithCancel(flightContext{detachedContext: detachedContext{parent: ctx}, call: call})
+		g.calls[key] = call
+		go g.run(flightCtx, key, call, fn)
+	}
+	call.waiters++
+	call.raise(priorityFromContext(ctx))
+	g.mu.Unlock()
+	if g.joined != nil {
+		g.joined(key)
+	}
+
+	select {
+	case <-call.done:
+		return call.value, nil
+	case <-ctx.Done():
+		g.leave(key, call)
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fn func(ctx context.Context) interface{}) {
+	defer call.cancel()
+	call.value = fn(ctx)
+
+	g.mu.Lock()
+	g.forget(key, call)
+	g.mu.Unlock()
+	close(call.done)
+	if g.finished != nil {
+		g.finished(key)
+	}
+}
+
+// leave removes caller from call and cancels call if it was the last one
+func (g *flightGroup) leave(key string, call *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	// nobody waits for result anymore, so new callers should start new call
+	g.forget(key, call)
+	call.cancel()
+}
+
+func (g *flightGroup) forget(key string, call *flightCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// lemmaResult and searchResult are values shared by GetLemma and Search flights
+type lemmaResult struct {
+	lemmas []*parser.Lemma
+	page   *Page
+	err    error
+}
+
+type searchResult struct {
+	lemmaID     string
+	suggestions []string
+	err         error
+}
+
+// detachedContext keeps values of parent context, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// flightContext is detached context of the first caller of flight with priority of call
+type flightContext struct {
+	detachedContext
+	call *flightCall
+}
+
+func (c flightContext) Value(key interface{}) interface{} {
+	if _, ok := key.(priorityKey); ok {
+		return Priority(atomic.LoadInt32(&c.call.priority))
+	}
+	return c.parent.Value(key)
+}
@@ -0,0 +1,139 @@
+package querier
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroupShared(t *testing.T) {
+	var group flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) interface{} {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value"
+	}
+	const callers = 10
+	var started, finished sync.WaitGroup
+	started.Add(callers)
+	finished.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer finished.Done()
+			started.Done()
+			value, err := group.Do(context.TODO(), "key", fn)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+	}
+	started.Wait()
+	// give callers time to join the flight
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	finished.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestFlightGroupPriority(t *testing.T) {
+	var group flightGroup
+	joined := make(chan struct{})
+	priorities := make(chan Priority, 2)
+	fn := func(ctx context.Context) interface{} {
+		priorities <- priorityFromContext(ctx)
+		<-joined
+		priorities <- priorityFromContext(ctx)
+		return "value"
+	}
+	batch := make(chan error)
+	go func() {
+		_, err := group.Do(WithPriority(context.Background(), PriorityBatch), "key", fn)
+		batch <- err
+	}()
+	assert.Equal(t, PriorityBatch, <-priorities)
+
+	interactive := make(chan error)
+	go func() {
+		_, err := group.Do(WithPriority(context.Background(), PriorityInteractive), "key", fn)
+		interactive <- err
+	}()
+	// wait until interactive caller joins the flight
+	for {
+		group.mu.Lock()
+		waiters := group.calls["key"].waiters
+		group.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(joined)
+	assert.Equal(t, PriorityInteractive, <-priorities)
+	assert.NoError(t, <-batch)
+	assert.NoError(t, <-interactive)
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	t.Run("one of callers leaves", func(t *testing.T) {
+		var group flightGroup
+		release := make(chan struct{})
+		fn := func(ctx context.Context) interface{} {
+			select {
+			case <-release:
+				return "value"
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		leaving := make(chan error)
+		go func() {
+			_, err := group.Do(ctx, "key", fn)
+			leaving <- err
+		}()
+		staying := make(chan interface{})
+		go func() {
+			value, err := group.Do(context.Background(), "key", fn)
+			assert.NoError(t, err)
+			staying <- value
+		}()
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+		assert.Equal(t, context.Canceled, <-leaving)
+		close(release)
+		assert.Equal(t, "value", <-staying)
+	})
+	t.Run("all callers leave", func(t *testing.T) {
+		var group flightGroup
+		cancelled := make(chan struct{})
+		fn := func(ctx context.Context) interface{} {
+			<-ctx.Done()
+			close(cancelled)
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := group.Do(ctx, "key", fn)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("flight was not cancelled after all callers left")
+		}
+	})
+}
+
+func TestDetachContext(t *testing.T) {
+	type ctxKey struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	cancel()
+	ctx := detachContext(parent)
+	assert.NoError(t, ctx.Err())
+	assert.Nil(t, ctx.Done())
+	assert.Equal(t, "value", ctx.Value(ctxKey{}))
+}
@@ -0,0 +1,137 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrorKind is kind of error for which negative caching can be configured separately
+type ErrorKind int
+
+const (
+	// ErrorKindOther is for errors that don't fit other kinds
+	ErrorKindOther ErrorKind = iota
+	// ErrorKindNotFound is for queries without lemma: ErrSuggestions and ErrEmptyLemmaID
+	ErrorKindNotFound
+	// ErrorKindParse is for pages that can not be parsed
+	ErrorKindParse
+	// ErrorKindRateLimited is for 429 responses
+	ErrorKindRateLimited
+	// ErrorKindServer is for 5xx responses
+	ErrorKindServer
+	// ErrorKindStatus is for other unexpected responses, like 404
+	ErrorKindStatus
+	// ErrorKindNetwork is for requests that failed without response
+	ErrorKindNetwork
+)
+
+// ClassifyError returns kind of error returned by Querier
+func ClassifyError(err error) ErrorKind {
+	var statusErr *StatusError
+	var parseErr *ParseError
+	var urlErr *url.Error
+	var peerErr *PeerError
+	switch {
+	case errors.Is(err, ErrSuggestions), errors.Is(err, ErrEmptyLemmaID):
+		return ErrorKindNotFound
+	case errors.As(err, &parseErr):
+		return ErrorKindParse
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return ErrorKindRateLimited
+		case statusErr.StatusCode >= http.StatusInternalServerError:
+			return ErrorKindServer
+		default:
+			return ErrorKindStatus
+		}
+	case errors.As(err, &urlErr):
+		return ErrorKindNetwork
+	case errors.As(err, &peerErr):
+		return peerErr.Kind
+	default:
+		return ErrorKindOther
+	}
+}
+
+// isTransient reports if error of kind says nothing about lemma or query itself
+func (k ErrorKind) isTransient() bool {
+	return k == ErrorKindRateLimited || k == ErrorKindServer || k == ErrorKindNetwork
+}
+
+// FreshnessPolicy specifies how long cached records are used before they are fetched again
+type FreshnessPolicy struct {
+	// QueryMaxAge and LemmaMaxAge specify how long successful records are fresh.
+	// Zero value means that records are always fresh
+	QueryMaxAge time.Duration
+	LemmaMaxAge time.Duration
+	// StaleWhileRevalidate specifies how long record is still returned after it became stale,
+	// while it's refreshed in background. Older records are refreshed before they are returned
+	StaleWhileRevalidate time.Duration
+	// ErrorTTL specifies how long errors of each kind are cached.
+	// Negative TTL means that errors of this kind are not cached at all.
+	// Errors are never cached forever, so zero TTL means DefaultErrorTTL like missing kind
+	ErrorTTL map[ErrorKind]time.Duration
+	// DefaultErrorTTL is used for kinds missing in ErrorTTL. Zero value means 24 hours
+	DefaultErrorTTL time.Duration
+}
+
+type freshness int
+
+const (
+	fresh freshness = iota
+	stale
+	expired
+)
+
+// check returns freshness of successful record of type t created at createdAt
+func (p *FreshnessPolicy) check(t keyType, createdAt time.Time) freshness {
+	if p == nil {
+		return fresh
+	}
+	maxAge := p.LemmaMaxAge
+	if t == queryKey {
+		maxAge = p.QueryMaxAge
+	}
+	if maxAge <= 0 {
+		return fresh
+	}
+	age := time.Since(createdAt)
+	switch {
+	case age <= maxAge:
+		return fresh
+	case age <= maxAge+p.StaleWhileRevalidate:
+		return stale
+	default:
+		return expired
+	}
+}
+
+// errorTTL returns how long err should be cached, negative value means that it should not be cached
+func (p *FreshnessPolicy) errorTTL(err error) time.Duration {
+	if p == nil {
+		return ttlForErros
+	}
+	if ttl := p.ErrorTTL[ClassifyError(err)]; ttl != 0 {
+		return ttl
+	}
+	if p.DefaultErrorTTL != 0 {
+		return p.DefaultErrorTTL
+	}
+	return ttlForErros
+}
+
+type refreshKey struct{}
+
+// WithRefresh returns context with which Cached ignores cached records and fetches them again
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+func refreshRequested(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
@@ -0,0 +1,188 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		kind ErrorKind
+	}{
+		"suggestions":   {err: ErrSuggestions, kind: ErrorKindNotFound},
+		"empty lemmaID": {err: fmt.Errorf("wrapped: %w", ErrEmptyLemmaID), kind: ErrorKindNotFound},
+		"parse":         {err: &ParseError{Err: errors.New("parse")}, kind: ErrorKindParse},
+		"rate limited":  {err: fmt.Errorf("failed: %w", &StatusError{StatusCode: http.StatusTooManyRequests}), kind: ErrorKindRateLimited},
+		"server":        {err: &StatusError{StatusCode: http.StatusBadGateway}, kind: ErrorKindServer},
+		"not found":     {err: &StatusError{StatusCode: http.StatusNotFound}, kind: ErrorKindStatus},
+		"network":       {err: fmt.Errorf("failed: %w", &url.Error{Op: "Get", Err: errors.New("reset")}), kind: ErrorKindNetwork},
+		"other":         {err: errors.New("other"), kind: ErrorKindOther},
+	}
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.kind, ClassifyError(tc.err))
+		})
+	}
+}
+
+func TestFreshnessPolicy(t *testing.T) {
+	var nilPolicy *FreshnessPolicy
+	assert.Equal(t, fresh, nilPolicy.check(lemmaKey, time.Time{}))
+	assert.Equal(t, ttlForErros, nilPolicy.errorTTL(errors.New("error")))
+
+	policy := &FreshnessPolicy{
+		LemmaMaxAge:          time.Hour,
+		StaleWhileRevalidate: time.Hour,
+		ErrorTTL: map[ErrorKind]time.Duration{
+			ErrorKindRateLimited: time.Minute,
+			ErrorKindNotFound:    0,
+		},
+		DefaultErrorTTL: time.Second,
+	}
+	now := time.Now()
+	assert.Equal(t, fresh, policy.check(lemmaKey, now))
+	assert.Equal(t, stale, policy.check(lemmaKey, now.Add(-time.Hour*3/2)))
+	assert.Equal(t, expired, policy.check(lemmaKey, now.Add(-time.Hour*3)))
+	// QueryMaxAge is not set, so queries are always fresh
+	assert.Equal(t, fresh, policy.check(queryKey, now.Add(-time.Hour*3)))
+
+	assert.Equal(t, time.Minute, policy.errorTTL(&StatusError{StatusCode: http.StatusTooManyRequests}))
+	assert.Equal(t, time.Second, policy.errorTTL(errors.New("other")))
+	// zero ttl doesn't cache error forever
+	assert.Equal(t, time.Second, policy.errorTTL(ErrSuggestions))
+	policy.DefaultErrorTTL = 0
+	assert.Equal(t, ttlForErros, policy.errorTTL(ErrSuggestions))
+}
+
+// putAgedLemma stores lemmas as if they were stored age ago
+func putAgedLemma(t *testing.T, storage *Storage, lemmaID string, lemmas []*parser.Lemma, age time.Duration) {
+	data, err := json.Marshal(&CachedLemma{
+		Lemmas:    lemmas,
+		CreatedAt: time.Now().Add(-age),
+	})
+	if err != nil {
+		t.Fatalf("can not marshal lemma: %v", err)
+	}
+	if err := storage.putEntry(marshalKey(lemmaID, lemmaKey), data, 0); err != nil {
+		t.Fatalf("can not put lemma: %v", err)
+	}
+}
+
+func TestCachedFreshness(t *testing.T) { // nolint:funlen // test
+	storage := getStorage(t)
+	oldLemmas := []*parser.Lemma{{Lemma: "old"}}
+	newLemmas := []*parser.Lemma{{Lemma: "new"}}
+	config := &CachedConfig{
+		Freshness: &FreshnessPolicy{
+			LemmaMaxAge:          time.Hour,
+			StaleWhileRevalidate: time.Hour,
+		},
+	}
+	t.Run("fresh", func(t *testing.T) {
+		putAgedLemma(t, storage, "fresh", oldLemmas, time.Minute)
+		q := &mocks.QueryInterface{}
+		cached := NewCached(q, storage.Store, config)
+
+		lemmas, err := cached.GetLemma(context.TODO(), "fresh")
+		assert.NoError(t, err)
+		assert.Equal(t, oldLemmas, lemmas)
+		q.AssertExpectations(t)
+	})
+	t.Run("stale while revalidate", func(t *testing.T) {
+		putAgedLemma(t, storage, "stale", oldLemmas, time.Hour*3/2)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "stale").Return(newLemmas, nil).Once()
+		cached := NewCached(q, storage.Store, config)
+
+		lemmas, err := cached.GetLemma(context.TODO(), "stale")
+		assert.NoError(t, err)
+		assert.Equal(t, oldLemmas, lemmas)
+		// wait for background refresh
+		cached.background.Wait()
+		lemmas, err = cached.GetLemma(context.TODO(), "stale")
+		assert.NoError(t, err)
+		assert.Equal(t, newLemmas, lemmas)
+		q.AssertExpectations(t)
+	})
+	t.Run("expired", func(t *testing.T) {
+		putAgedLemma(t, storage, "expired", oldLemmas, time.Hour*3)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "expired").Return(newLemmas, nil).Once()
+		cached := NewCached(q, storage.Store, config)
+
+		lemmas, err := cached.GetLemma(context.TODO(), "expired")
+		assert.NoError(t, err)
+		assert.Equal(t, newLemmas, lemmas)
+		q.AssertExpectations(t)
+	})
+	t.Run("expired with transient error", func(t *testing.T) {
+		putAgedLemma(t, storage, "unavailable", oldLemmas, time.Hour*3)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "unavailable").
+			Return([]*parser.Lemma(nil), &StatusError{StatusCode: http.StatusServiceUnavailable})
+		cached := NewCached(q, storage.Store, config)
+
+		_, err := cached.GetLemma(context.TODO(), "unavailable")
+		assert.Error(t, err)
+		stored, err := storage.GetLemma("unavailable")
+		assert.NoError(t, err)
+		assert.Equal(t, oldLemmas, stored.Lemmas, "transient error must not replace stored lemmas")
+	})
+	t.Run("forced refresh", func(t *testing.T) {
+		putAgedLemma(t, storage, "forced", oldLemmas, 0)
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "forced").Return(newLemmas, nil).Once()
+		cached := NewCached(q, storage.Store, config)
+
+		lemmas, err := cached.GetLemma(WithRefresh(context.TODO()), "forced")
+		assert.NoError(t, err)
+		assert.Equal(t, newLemmas, lemmas)
+		q.AssertExpectations(t)
+	})
+}
+
+func TestCachedErrorTTL(t *testing.T) {
+	storage := getStorage(t)
+	config := &CachedConfig{
+		Freshness: &FreshnessPolicy{
+			ErrorTTL: map[ErrorKind]time.Duration{
+				ErrorKindNotFound:    time.Hour,
+				ErrorKindRateLimited: -1,
+			},
+		},
+	}
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "helo").
+		Return("", []string{"hello"}, ErrSuggestions).Once()
+	q.On("Search", mock.Anything, "limited").
+		Return("", []string(nil), &StatusError{StatusCode: http.StatusTooManyRequests}).Twice()
+	cached := NewCached(q, storage.Store, config)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := cached.Search(context.TODO(), "helo")
+		assert.True(t, errors.Is(err, ErrSuggestions))
+		_, _, err = cached.Search(context.TODO(), "limited")
+		assert.Error(t, err)
+	}
+	q.AssertExpectations(t)
+	entry, err := storage.Store.Get(marshalKey("helo", queryKey))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Minute)
+	_, err = storage.Store.Get(marshalKey("limited", queryKey))
+	assert.Equal(t, store.ErrNotFound, err)
+}
@@ -0,0 +1,344 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// EntryKind is kind of dictionary entry of lemma
+type EntryKind string
+
+const (
+	EntryWord        EntryKind = "word"
+	EntryPhrasalVerb EntryKind = "phrasal-verb"
+	EntryIdiom       EntryKind = "idiom"
+	// EntryPhrase is entry of several words that is neither phrasal verb nor idiom
+	EntryPhrase EntryKind = "phrase"
+)
+
+// LemmaKind returns kind of entry of lemma by its part of speech and headword
+func LemmaKind(lemma *parser.Lemma) EntryKind {
+	for _, pos := range lemma.PartOfSpeech {
+		switch pos {
+		case "phrasal verb":
+			return EntryPhrasalVerb
+		case "idiom":
+			return EntryIdiom
+		}
+	}
+	if len(strings.Fields(lemma.Lemma)) > 1 {
+		return EntryPhrase
+	}
+	return EntryWord
+}
+
+// indexField is field of lemmas that has secondary index
+type indexField byte
+
+const (
+	indexHeadword     indexField = 'h'
+	indexPartOfSpeech indexField = 'p'
+	indexGrammar      indexField = 'g'
+	indexLanguage     indexField = 'l'
+	indexKind         indexField = 'k'
+)
+
+// indexTerm is value of field of one of lemmas
+type indexTerm struct {
+	Field indexField `json:"f"`
+	Value string     `json:"v"`
+}
+
+func newIndexTerm(field indexField, value string) indexTerm {
+	return indexTerm{Field: field, Value: strings.ToLower(strings.TrimSpace(value))}
+}
+
+// postingPrefix is prefix of index keys of lemmas with term
+func (t indexTerm) postingPrefix() []byte {
+	key := make([]byte, 0, len(t.Value)+3) // nolint:gomnd // type, field and separator
+	key = append(key, byte(indexKey), byte(t.Field))
+	key = append(key, t.Value...)
+	return append(key, 0)
+}
+
+func (t indexTerm) postingKey(lemmaID string) []byte {
+	return append(t.postingPrefix(), lemmaID...)
+}
+
+// lemmaTerms returns unique terms of every lemma
+func lemmaTerms(lemmas []*parser.Lemma) []indexTerm {
+	seen := make(map[indexTerm]bool)
+	var terms []indexTerm
+	add := func(term indexTerm) {
+		if term.Value == "" || seen[term] {
+			return
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	for _, lemma := range lemmas {
+		for _, term := range singleLemmaTerms(lemma) {
+			add(term)
+		}
+	}
+	return terms
+}
+
+func singleLemmaTerms(lemma *parser.Lemma) []indexTerm {
+	terms := []indexTerm{
+		newIndexTerm(indexHeadword, lemma.Lemma),
+		newIndexTerm(indexLanguage, lemma.Language),
+		newIndexTerm(indexKind, string(LemmaKind(lemma))),
+	}
+	for _, pos := range lemma.PartOfSpeech {
+		terms = append(terms, newIndexTerm(indexPartOfSpeech, pos))
+	}
+	for _, grammar := range lemma.Grammar {
+		terms = append(terms, newIndexTerm(indexGrammar, grammar))
+	}
+	return terms
+}
+
+// LemmaQuery selects cached lemmas by indexed fields. Empty fields match any value,
+// values are compared case insensitively. Lemmas don't have CEFR levels, because parser doesn't extract them.
+type LemmaQuery struct {
+	Headword     string
+	PartOfSpeech string
+	// Grammar is grammar code without brackets, like "U" or "T"
+	Grammar string
+	// Language is dictionary of lemma, like "british" or "american-english"
+	Language string
+	Kind     EntryKind
+}
+
+func (q *LemmaQuery) terms() []indexTerm {
+	var terms []indexTerm
+	for _, term := range []indexTerm{
+		newIndexTerm(indexHeadword, q.Headword),
+		newIndexTerm(indexPartOfSpeech, q.PartOfSpeech),
+		newIndexTerm(indexGrammar, q.Grammar),
+		newIndexTerm(indexLanguage, q.Language),
+		newIndexTerm(indexKind, string(q.Kind)),
+	} {
+		if term.Value != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// match reports if single lemma satisfies every condition of query
+func (q *LemmaQuery) match(lemma *parser.Lemma) bool {
+	has := make(map[indexTerm]bool)
+	for _, term := range singleLemmaTerms(lemma) {
+		has[term] = true
+	}
+	for _, term := range q.terms() {
+		if !has[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexedLemma is lemma found by FindLemmas
+type IndexedLemma struct {
+	LemmaID string        `json:"lemma_id"`
+	Lemma   *parser.Lemma `json:"lemma"`
+}
+
+// FindLemmas calls fn for every cached lemma that matches query in order of lemmaIDs.
+// Records are selected by indexes and then checked, so index entries of removed records are ignored.
+// Error of fn stops search.
+func (s *Storage) FindLemmas(query *LemmaQuery, fn func(*IndexedLemma) error) error {
+	lemmaIDs, err := s.candidateLemmas(query.terms())
+	if err != nil {
+		return err
+	}
+	for _, lemmaID := range lemmaIDs {
+		cached, err := s.GetLemma(lemmaID)
+		if isMissingRecord(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can not get lemma '%s': %w", lemmaID, err)
+		}
+		for _, lemma := range cached.Lemmas {
+			if !query.match(lemma) {
+				continue
+			}
+			if err := fn(&IndexedLemma{LemmaID: lemmaID, Lemma: lemma}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// candidateLemmas returns sorted lemmaIDs that have every term, without terms it returns every lemmaID
+func (s *Storage) candidateLemmas(terms []indexTerm) ([]string, error) {
+	var candidates map[string]bool
+	if len(terms) == 0 {
+		candidates = make(map[string]bool)
+		err := s.Store.Iterate([]byte{byte(lemmaKey)}, func(entry *store.Entry) error {
+			candidates[string(entry.Key[1:])] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can not iterate lemmas: %w", err)
+		}
+	}
+	for _, term := range terms {
+		prefix := term.postingPrefix()
+		found := make(map[string]bool)
+		err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+			lemmaID := string(entry.Key[len(prefix):])
+			if candidates == nil || candidates[lemmaID] {
+				found[lemmaID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can not iterate index: %w", err)
+		}
+		candidates = found
+	}
+	lemmaIDs := make([]string, 0, len(candidates))
+	for lemmaID := range candidates {
+		lemmaIDs = append(lemmaIDs, lemmaID)
+	}
+	sort.Strings(lemmaIDs)
+	return lemmaIDs, nil
+}
+
+// indexLemma adds to batch writes that replace index entries of lemmaID with entries of lemmas
+// including full-text index, they expire with lemmas after ttl
+func (s *Storage) indexLemma(batch *writeBatch, lemmaID string, lemmas []*parser.Lemma, ttl time.Duration) error {
+	terms := lemmaTerms(lemmas)
+	if len(terms) == 0 {
+		return s.unindexLemma(batch, lemmaID)
+	}
+	indexed, err := s.indexedTerms(lemmaID)
+	if err != nil {
+		return err
+	}
+	current := make(map[indexTerm]bool, len(terms))
+	for _, term := range terms {
+		current[term] = true
+	}
+	for _, term := range indexed {
+		if !current[term] {
+			batch.delete(term.postingKey(lemmaID))
+		}
+	}
+	for _, term := range terms {
+		batch.put(term.postingKey(lemmaID), nil, ttl)
+	}
+	data, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	batch.put(marshalKey(lemmaID, indexedKey), data, ttl)
+	return s.indexText(batch, lemmaID, lemmas, ttl)
+}
+
+// unindexLemma adds to batch removal of every index entry of lemmaID including full-text index
+func (s *Storage) unindexLemma(batch *writeBatch, lemmaID string) error {
+	indexed, err := s.indexedTerms(lemmaID)
+	if err != nil {
+		return err
+	}
+	for _, term := range indexed {
+		batch.delete(term.postingKey(lemmaID))
+	}
+	batch.delete(marshalKey(lemmaID, indexedKey))
+	return s.unindexText(batch, lemmaID)
+}
+
+// indexedTerms returns terms under which lemmaID is indexed now
+func (s *Storage) indexedTerms(lemmaID string) ([]indexTerm, error) {
+	entry, err := s.Store.Get(marshalKey(lemmaID, indexedKey))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not get index entry: %w", err)
+	}
+	var terms []indexTerm
+	if err := json.Unmarshal(entry.Value, &terms); err != nil {
+		// broken entry is replaced, its postings are ignored by FindLemmas anyway
+		return nil, nil
+	}
+	return terms, nil
+}
+
+// Reindex drops indexes and aliases and builds them again from every cached lemma and query.
+// It's needed for records that were cached before indexes or changed not through Storage.
+// It returns number of indexed lemmas
+func (s *Storage) Reindex() (int, error) {
+	defer s.resetTextStats()
+	for _, t := range []keyType{indexKey, indexedKey, textKey, textDocKey, aliasKey} {
+		var keys [][]byte
+		err := s.Store.Iterate([]byte{byte(t)}, func(entry *store.Entry) error {
+			keys = append(keys, entry.Key)
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("can not iterate index: %w", err)
+		}
+		for _, key := range keys {
+			if err := s.Store.Delete(key); err != nil {
+				return 0, fmt.Errorf("can not delete index entry: %w", err)
+			}
+		}
+	}
+	indexed := 0
+	prefix := []byte{byte(lemmaKey)}
+	err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+		lemma, _, err := decodeLemma(entry.Value, s.migrator())
+		if err != nil || lemma.Error != "" {
+			return nil
+		}
+		ttl, alive := remainingTTL(entry)
+		if !alive {
+			return nil
+		}
+		batch := &writeBatch{}
+		if err := s.indexLemma(batch, string(entry.Key[len(prefix):]), lemma.Lemmas, ttl); err != nil {
+			return err
+		}
+		if err := s.apply(batch); err != nil {
+			return err
+		}
+		indexed++
+		return nil
+	})
+	if err != nil {
+		return indexed, fmt.Errorf("can not reindex lemmas: %w", err)
+	}
+	prefix = []byte{byte(queryKey)}
+	err = s.Store.Iterate(prefix, func(entry *store.Entry) error {
+		query, _, err := decodeQuery(entry.Value, s.migrator())
+		if err != nil || query.Error != "" {
+			return nil
+		}
+		ttl, alive := remainingTTL(entry)
+		if !alive {
+			return nil
+		}
+		batch := &writeBatch{}
+		s.linkQuery(batch, string(entry.Key[len(prefix):]), "", query.LemmaID, ttl)
+		return s.apply(batch)
+	})
+	if err != nil {
+		return indexed, fmt.Errorf("can not link queries: %w", err)
+	}
+	return indexed, nil
+}
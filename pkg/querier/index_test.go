@@ -0,0 +1,159 @@
+package querier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func findLemmas(t *testing.T, storage *Storage, query *LemmaQuery) []*IndexedLemma {
+	var found []*IndexedLemma
+	assert.NoError(t, storage.FindLemmas(query, func(lemma *IndexedLemma) error {
+		found = append(found, lemma)
+		return nil
+	}))
+	return found
+}
+
+func newIndexedStorage(t *testing.T) *Storage {
+	storage := NewStorage(store.NewMemory(0), nil)
+	for name, cached := range loadFixtureLemmas(t) {
+		lemmaID := strings.TrimSuffix(name, ".html")
+		assert.NoError(t, storage.PutLemma(lemmaID, cached.Lemmas, nil))
+	}
+	return storage
+}
+
+func TestLemmaKind(t *testing.T) {
+	assert.Equal(t, EntryWord, LemmaKind(&parser.Lemma{Lemma: "print", PartOfSpeech: []string{"noun"}}))
+	assert.Equal(t, EntryPhrasalVerb, LemmaKind(&parser.Lemma{Lemma: "get out", PartOfSpeech: []string{"phrasal verb"}}))
+	assert.Equal(t, EntryIdiom, LemmaKind(&parser.Lemma{Lemma: "to begin with", PartOfSpeech: []string{"idiom"}}))
+	assert.Equal(t, EntryPhrase, LemmaKind(&parser.Lemma{Lemma: "in print"}))
+}
+
+func TestFindLemmas(t *testing.T) {
+	storage := newIndexedStorage(t)
+
+	found := findLemmas(t, storage, &LemmaQuery{Kind: EntryPhrasalVerb})
+	assert.NotEmpty(t, found)
+	for _, lemma := range found {
+		assert.Equal(t, "get-out", lemma.LemmaID)
+		assert.Equal(t, []string{"phrasal verb"}, lemma.Lemma.PartOfSpeech)
+	}
+
+	found = findLemmas(t, storage, &LemmaQuery{PartOfSpeech: "noun", Grammar: "u", Language: "British"})
+	assert.Len(t, found, 2)
+	for _, lemma := range found {
+		assert.Equal(t, "print", lemma.LemmaID)
+		assert.Equal(t, []string{"U"}, lemma.Lemma.Grammar)
+		assert.Equal(t, "british", lemma.Lemma.Language)
+	}
+
+	// conditions are checked for every lemma, not for the whole record
+	assert.Empty(t, findLemmas(t, storage, &LemmaQuery{Headword: "print", Kind: EntryIdiom}))
+
+	all := findLemmas(t, storage, &LemmaQuery{})
+	assert.True(t, len(all) > len(found))
+	assert.Equal(t, "get-out", all[0].LemmaID)
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	storage := newIndexedStorage(t)
+	nouns := &LemmaQuery{Headword: "print", PartOfSpeech: "noun"}
+	assert.NotEmpty(t, findLemmas(t, storage, nouns))
+
+	t.Run("replaced lemmas", func(t *testing.T) {
+		verbs := []*parser.Lemma{{Lemma: "print", PartOfSpeech: []string{"verb"}, Language: "british"}}
+		assert.NoError(t, storage.PutLemma("print", verbs, nil))
+		assert.Empty(t, findLemmas(t, storage, nouns))
+		assert.Len(t, findLemmas(t, storage, &LemmaQuery{Headword: "print"}), 1)
+		terms, err := storage.indexedTerms("print")
+		assert.NoError(t, err)
+		assert.Len(t, terms, 4)
+	})
+	t.Run("error record", func(t *testing.T) {
+		assert.NoError(t, storage.PutLemma("print", nil, ErrEmptyLemmaID))
+		assert.Empty(t, findLemmas(t, storage, &LemmaQuery{Headword: "print"}))
+		assert.NoError(t, storage.Store.Iterate(marshalKey("", indexKey), func(entry *store.Entry) error {
+			assert.NotContains(t, string(entry.Key), "\x00print")
+			return nil
+		}))
+	})
+	t.Run("deleted lemmas", func(t *testing.T) {
+		assert.NotEmpty(t, findLemmas(t, storage, &LemmaQuery{Kind: EntryIdiom}))
+		assert.NoError(t, storage.DeleteLemma("to-begin-with"))
+		for _, lemma := range findLemmas(t, storage, &LemmaQuery{Kind: EntryIdiom}) {
+			assert.Equal(t, "get-out", lemma.LemmaID)
+		}
+		_, err := storage.Store.Get(marshalKey("to-begin-with", indexedKey))
+		assert.Equal(t, store.ErrNotFound, err)
+	})
+}
+
+// batchCountingStore counts batches and single writes
+type batchCountingStore struct {
+	*store.Memory
+	batches int
+	singles int
+}
+
+func (s *batchCountingStore) Put(key, value []byte, ttl time.Duration) error {
+	s.singles++
+	return s.Memory.Put(key, value, ttl)
+}
+
+func (s *batchCountingStore) Delete(key []byte) error {
+	s.singles++
+	return s.Memory.Delete(key)
+}
+
+func (s *batchCountingStore) ApplyWrites(writes []store.Write) error {
+	s.batches++
+	return s.Memory.ApplyWrites(writes)
+}
+
+func TestIndexWrittenWithRecord(t *testing.T) {
+	cache := &batchCountingStore{Memory: store.NewMemory(0)}
+	storage := NewStorage(cache, nil)
+	lemmas := loadFixtureLemmas(t)["print.html"].Lemmas
+	assert.NoError(t, storage.PutLemma("print", lemmas, nil))
+	assert.NoError(t, storage.PutLemma("print", lemmas[:1], nil))
+	assert.NoError(t, storage.PutQuery("printing", "print", nil, nil))
+	assert.Equal(t, 3, cache.batches)
+	assert.Zero(t, cache.singles)
+	assert.Len(t, findLemmas(t, storage, &LemmaQuery{Headword: "print"}), 1)
+	aliases, err := storage.Aliases("print")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"printing"}, aliases)
+
+	assert.NoError(t, storage.DeleteLemma("print"))
+	assert.Equal(t, 6, cache.batches, "lemma, its page and query are deleted in own batches")
+	assert.Empty(t, findLemmas(t, storage, &LemmaQuery{}))
+	assert.Empty(t, listKeys(t, storage, &RecordFilter{}))
+	assert.Zero(t, cache.singles)
+}
+
+func TestReindex(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), nil)
+	lemmas := loadFixtureLemmas(t)["print.html"]
+	data, err := encodeLemma(lemmas, nil)
+	assert.NoError(t, err)
+	// lemma cached before indexes
+	assert.NoError(t, storage.putEntry(marshalKey("print", lemmaKey), data, 0))
+	// stale entry of removed lemma
+	assert.NoError(t, storage.Store.Put(newIndexTerm(indexHeadword, "gone").postingKey("gone"), nil, 0))
+	query := &LemmaQuery{PartOfSpeech: "verb"}
+	assert.Empty(t, findLemmas(t, storage, query))
+
+	indexed, err := storage.Reindex()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, indexed)
+	assert.NotEmpty(t, findLemmas(t, storage, query))
+	_, err = storage.Store.Get(newIndexTerm(indexHeadword, "gone").postingKey("gone"))
+	assert.Equal(t, store.ErrNotFound, err)
+}
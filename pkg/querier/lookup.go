@@ -0,0 +1,63 @@
+package querier
+
+import (
+	"context"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/lookup"
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+// LookupResult is result of Lookup
+type LookupResult = lookup.Result
+
+// LookupTimings specifies duration of Lookup steps
+type LookupTimings = lookup.Timings
+
+// Lookup searches query and gets lemmas for found lemmaID.
+// If query was not found, result contains suggestions and error is ErrSuggestions.
+// Result is returned even with error, so timings and suggestions are available.
+func (q *Querier) Lookup(ctx context.Context, query string) (*LookupResult, error) {
+	return lookupWith(ctx, query,
+		func(ctx context.Context, query string) (string, []string, bool, error) {
+			lemmaID, suggestions, err := q.Search(ctx, query)
+			return lemmaID, suggestions, false, err
+		},
+		func(ctx context.Context, lemmaID string) ([]*parser.Lemma, bool, error) {
+			lemmas, err := q.GetLemma(ctx, lemmaID)
+			return lemmas, false, err
+		},
+	)
+}
+
+// searchFunc and getLemmaFunc additionally report if result was taken from cache
+type searchFunc func(ctx context.Context, query string) (lemmaID string, suggestions []string, cached bool, err error)
+type getLemmaFunc func(ctx context.Context, lemmaID string) (lemmas []*parser.Lemma, cached bool, err error)
+
+func lookupWith(ctx context.Context, query string, search searchFunc, getLemma getLemmaFunc) (*LookupResult, error) {
+	started := time.Now()
+	result := &LookupResult{}
+	finish := func(err error) (*LookupResult, error) {
+		result.Timings.Total = time.Since(started)
+		return result, err
+	}
+
+	lemmaID, suggestions, searchCached, err := search(ctx, query)
+	result.Timings.Search = time.Since(started)
+	result.Suggestions = suggestions
+	result.Cached = searchCached
+	if err != nil {
+		return finish(err)
+	}
+	if lemmaID == "" {
+		return finish(ErrEmptyLemmaID)
+	}
+	result.LemmaID = lemmaID
+
+	lemmaStarted := time.Now()
+	lemmas, lemmaCached, err := getLemma(ctx, lemmaID)
+	result.Timings.GetLemma = time.Since(lemmaStarted)
+	result.Lemmas = lemmas
+	result.Cached = searchCached && lemmaCached
+	return finish(err)
+}
@@ -0,0 +1,66 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+func TestQuerierLookup(t *testing.T) {
+	testLemmas := []*parser.Lemma{
+		{
+			Lemma: "hello",
+		},
+	}
+	queryFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, path.Join(lemmaPath, "hello"), http.StatusFound)
+		},
+		"helo": func(w http.ResponseWriter, r *http.Request) {
+			redirectSuggestions(w, r, "helo", http.StatusFound)
+		},
+		"empty": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, lemmaPath, http.StatusFound)
+		},
+	}
+	suggestionFn := map[string]http.HandlerFunc{
+		"helo": func(w http.ResponseWriter, r *http.Request) {
+			err := json.NewEncoder(w).Encode([]string{"hello"})
+			assert.NoError(t, err)
+		},
+	}
+	lemmaFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			err := json.NewEncoder(w).Encode(testLemmas)
+			assert.NoError(t, err)
+		},
+	}
+	querier, clean := newTestQuerier(t, queryFn, suggestionFn, lemmaFn)
+	defer clean()
+
+	t.Run("found", func(t *testing.T) {
+		result, err := querier.Lookup(context.TODO(), "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", result.LemmaID)
+		assert.Equal(t, testLemmas, result.Lemmas)
+		assert.False(t, result.Cached)
+		assert.True(t, result.Timings.Total >= result.Timings.Search+result.Timings.GetLemma)
+	})
+	t.Run("suggestions", func(t *testing.T) {
+		result, err := querier.Lookup(context.TODO(), "helo")
+		assert.True(t, errors.Is(err, ErrSuggestions))
+		assert.Equal(t, []string{"hello"}, result.Suggestions)
+		assert.Empty(t, result.LemmaID)
+	})
+	t.Run("empty lemmaID", func(t *testing.T) {
+		_, err := querier.Lookup(context.TODO(), "empty")
+		assert.True(t, errors.Is(err, ErrEmptyLemmaID))
+	})
+}
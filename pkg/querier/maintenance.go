@@ -0,0 +1,111 @@
+package querier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultMaintenanceInterval = time.Minute * 10
+
+// MaintenanceConfig specifies periodic maintenance of store by Cached
+type MaintenanceConfig struct {
+	// Interval between garbage collections, zero means 10 minutes
+	Interval time.Duration
+	// DiscardRatio is part of badger value log file that should be garbage to rewrite the file.
+	// Zero means store.DefaultDiscardRatio
+	DiscardRatio float64
+	// Report is called after every maintenance, it can be nil
+	Report func(*MaintenanceReport)
+}
+
+// MaintenanceReport describes one maintenance of store
+type MaintenanceReport struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	// DiskSizeBefore and DiskSizeAfter are -1 if store doesn't report its size
+	DiskSizeBefore int64
+	DiskSizeAfter  int64
+	Flattened      bool
+	// Err is error of flatten or garbage collection
+	Err error
+}
+
+// Maintain collects garbage of store and flattens it if flatten is true.
+// It's run periodically if CachedConfig.Maintenance is set, but it can be run on demand too.
+func (c *Cached) Maintain(flatten bool) *MaintenanceReport {
+	c.maintenanceMu.Lock()
+	defer c.maintenanceMu.Unlock()
+	var discardRatio float64
+	if c.config.Maintenance != nil {
+		discardRatio = c.config.Maintenance.DiscardRatio
+	}
+	report := &MaintenanceReport{StartedAt: time.Now()}
+	var errs []error
+	var err error
+	if report.DiskSizeBefore, err = c.storage.DiskSize(); err != nil {
+		errs = append(errs, fmt.Errorf("can not get disk size: %w", err))
+	}
+	if flatten {
+		if err := c.storage.Flatten(); err != nil {
+			errs = append(errs, fmt.Errorf("flatten failed: %w", err))
+		} else {
+			report.Flattened = true
+		}
+	}
+	if err := c.storage.CollectGarbage(discardRatio); err != nil {
+		errs = append(errs, fmt.Errorf("garbage collection failed: %w", err))
+	}
+	if report.DiskSizeAfter, err = c.storage.DiskSize(); err != nil {
+		errs = append(errs, fmt.Errorf("can not get disk size: %w", err))
+	}
+	report.Duration = time.Since(report.StartedAt)
+	report.Err = joinErrors(errs)
+	return report
+}
+
+// DiskUsage returns size of store or -1 if store doesn't report it
+func (c *Cached) DiskUsage() (int64, error) {
+	return c.storage.DiskSize()
+}
+
+// startMaintenance runs maintenance periodically until Cached is closed
+func (c *Cached) startMaintenance(config *MaintenanceConfig) {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultMaintenanceInterval
+	}
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.backgroundCtx.Done():
+				return
+			case <-ticker.C:
+				report := c.Maintain(false)
+				if config.Report != nil {
+					config.Report(report)
+				}
+			}
+		}
+	}()
+}
+
+// joinErrors returns nil for empty errs, the only error or errors joined with AND like Cached.Close does
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, " AND "))
+}
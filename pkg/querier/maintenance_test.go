@@ -0,0 +1,63 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestCachedMaintenance(t *testing.T) {
+	memory := store.NewMemory(0)
+	assert.NoError(t, memory.Put([]byte("expired"), []byte("value"), time.Nanosecond))
+	assert.NoError(t, memory.Put([]byte("alive"), []byte("value"), 0))
+	time.Sleep(time.Millisecond)
+
+	reports := make(chan *MaintenanceReport, 100)
+	q := &mocks.QueryInterface{}
+	q.On("Close", context.TODO()).Return(nil).Once()
+	cached := NewCached(q, memory, &CachedConfig{
+		Maintenance: &MaintenanceConfig{
+			Interval: time.Millisecond * 10,
+			Report: func(report *MaintenanceReport) {
+				reports <- report
+			},
+		},
+	})
+	select {
+	case report := <-reports:
+		assert.NoError(t, report.Err)
+		assert.False(t, report.Flattened)
+		assert.True(t, report.DiskSizeAfter < report.DiskSizeBefore)
+	case <-time.After(time.Second):
+		t.Fatal("maintenance was not run")
+	}
+	assert.Equal(t, 1, memory.Len())
+
+	assert.NoError(t, cached.Close(context.TODO()))
+	reported := len(reports)
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, reported, len(reports), "maintenance must stop on close")
+	q.AssertExpectations(t)
+}
+
+func TestCachedMaintainOnDemand(t *testing.T) {
+	cached := NewCached(&mocks.QueryInterface{}, store.NewMemory(0), &CachedConfig{})
+	report := cached.Maintain(true)
+	assert.True(t, errors.Is(report.Err, ErrFlattenUnsupported))
+	assert.False(t, report.Flattened)
+
+	storage := getStorage(t)
+	cached = NewCached(&mocks.QueryInterface{}, storage.Store, &CachedConfig{})
+	report = cached.Maintain(true)
+	assert.NoError(t, report.Err)
+	assert.True(t, report.Flattened)
+	size, err := cached.DiskUsage()
+	assert.NoError(t, err)
+	assert.True(t, size >= 0)
+}
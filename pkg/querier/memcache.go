@@ -0,0 +1,130 @@
+package querier
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryCacheConfig limits in-process cache of decoded records. Zero limit means no limit
+type MemoryCacheConfig struct {
+	MaxEntries int
+	// MaxBytes limits total size of cached records, size of record is size of its encoded form
+	MaxBytes int64
+}
+
+// memoryCache is LRU of decoded records in front of CacheStore
+type memoryCache struct {
+	config *MemoryCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recency has the most recently used entry at front
+	recency *list.List
+	bytes   int64
+	// generation is changed on every invalidation, so records read from store
+	// before invalidation are not cached after it
+	generation uint64
+}
+
+type memoryEntry struct {
+	key       string
+	value     interface{}
+	size      int64
+	expiresAt time.Time
+}
+
+func newMemoryCache(config *MemoryCacheConfig) *memoryCache {
+	return &memoryCache{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		recency: list.New(),
+	}
+}
+
+// get returns decoded record of key if it's cached and not expired
+func (m *memoryCache) get(key []byte) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.entries[string(key)]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		m.remove(element)
+		return nil, false
+	}
+	m.recency.MoveToFront(element)
+	return entry.value, true
+}
+
+// currentGeneration should be taken before record is read from store and passed to add
+func (m *memoryCache) currentGeneration() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.generation
+}
+
+// add caches record if nothing was invalidated since generation
+func (m *memoryCache) add(key []byte, value interface{}, size int64, expiresAt time.Time, generation uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if generation != m.generation {
+		return
+	}
+	if m.config.MaxBytes > 0 && size > m.config.MaxBytes {
+		return
+	}
+	if element, ok := m.entries[string(key)]; ok {
+		m.remove(element)
+	}
+	entry := &memoryEntry{
+		key:       string(key),
+		value:     value,
+		size:      size,
+		expiresAt: expiresAt,
+	}
+	m.entries[entry.key] = m.recency.PushFront(entry)
+	m.bytes += size
+	for m.overflown() {
+		m.remove(m.recency.Back())
+	}
+}
+
+// invalidate removes key, so it's read from store next time
+func (m *memoryCache) invalidate(key []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generation++
+	if element, ok := m.entries[string(key)]; ok {
+		m.remove(element)
+	}
+}
+
+// purge removes all records
+func (m *memoryCache) purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generation++
+	m.entries = make(map[string]*list.Element)
+	m.recency.Init()
+	m.bytes = 0
+}
+
+func (m *memoryCache) size() (entries int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recency.Len(), m.bytes
+}
+
+func (m *memoryCache) overflown() bool {
+	return (m.config.MaxEntries > 0 && m.recency.Len() > m.config.MaxEntries) ||
+		(m.config.MaxBytes > 0 && m.bytes > m.config.MaxBytes)
+}
+
+func (m *memoryCache) remove(element *list.Element) {
+	entry := m.recency.Remove(element).(*memoryEntry)
+	delete(m.entries, entry.key)
+	m.bytes -= entry.size
+}
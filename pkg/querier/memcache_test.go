@@ -0,0 +1,127 @@
+package querier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestMemoryCacheLimits(t *testing.T) {
+	t.Run("entries", func(t *testing.T) {
+		m := newMemoryCache(&MemoryCacheConfig{MaxEntries: 2})
+		m.add([]byte("a"), "a", 1, time.Time{}, m.currentGeneration())
+		m.add([]byte("b"), "b", 1, time.Time{}, m.currentGeneration())
+		_, ok := m.get([]byte("a"))
+		assert.True(t, ok)
+		m.add([]byte("c"), "c", 1, time.Time{}, m.currentGeneration())
+
+		_, ok = m.get([]byte("b"))
+		assert.False(t, ok, "least recently used entry must be evicted")
+		entries, bytes := m.size()
+		assert.Equal(t, 2, entries)
+		assert.Equal(t, int64(2), bytes)
+	})
+	t.Run("bytes", func(t *testing.T) {
+		m := newMemoryCache(&MemoryCacheConfig{MaxBytes: 10})
+		m.add([]byte("a"), "a", 6, time.Time{}, m.currentGeneration())
+		m.add([]byte("b"), "b", 4, time.Time{}, m.currentGeneration())
+		m.add([]byte("c"), "c", 4, time.Time{}, m.currentGeneration())
+		m.add([]byte("huge"), "huge", 11, time.Time{}, m.currentGeneration())
+
+		_, ok := m.get([]byte("a"))
+		assert.False(t, ok)
+		_, ok = m.get([]byte("huge"))
+		assert.False(t, ok, "entry bigger than limit must not be cached")
+		entries, bytes := m.size()
+		assert.Equal(t, 2, entries)
+		assert.Equal(t, int64(8), bytes)
+	})
+	t.Run("expired", func(t *testing.T) {
+		m := newMemoryCache(&MemoryCacheConfig{})
+		m.add([]byte("a"), "a", 1, time.Now().Add(-time.Second), m.currentGeneration())
+		_, ok := m.get([]byte("a"))
+		assert.False(t, ok)
+	})
+}
+
+func TestMemoryCacheInvalidation(t *testing.T) {
+	m := newMemoryCache(&MemoryCacheConfig{})
+	m.add([]byte("a"), "a", 1, time.Time{}, m.currentGeneration())
+	// record is read from store before it's changed, but added to cache after
+	generation := m.currentGeneration()
+	m.invalidate([]byte("a"))
+	m.add([]byte("a"), "old", 1, time.Time{}, generation)
+
+	_, ok := m.get([]byte("a"))
+	assert.False(t, ok)
+
+	m.add([]byte("b"), "b", 1, time.Time{}, m.currentGeneration())
+	m.purge()
+	_, ok = m.get([]byte("b"))
+	assert.False(t, ok)
+}
+
+func TestStorageMemoryTier(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), &MemoryCacheConfig{MaxEntries: 10})
+	oldLemmas := []*parser.Lemma{{Lemma: "old"}}
+	newLemmas := []*parser.Lemma{{Lemma: "new"}}
+
+	_, err := storage.GetLemma("lemma")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.NoError(t, storage.PutLemma("lemma", oldLemmas, nil))
+	for i := 0; i < 3; i++ {
+		cached, err := storage.GetLemma("lemma")
+		assert.NoError(t, err)
+		assert.Equal(t, oldLemmas, cached.Lemmas)
+	}
+	assert.Equal(t, CacheStats{
+		Memory:        TierStats{Hits: 2, Misses: 2},
+		Store:         TierStats{Hits: 1, Misses: 1},
+		MemoryEntries: 1,
+		MemoryBytes:   storage.Stats().MemoryBytes,
+	}, storage.Stats())
+
+	assert.NoError(t, storage.PutLemma("lemma", newLemmas, nil))
+	cached, err := storage.GetLemma("lemma")
+	assert.NoError(t, err)
+	assert.Equal(t, newLemmas, cached.Lemmas)
+
+	assert.NoError(t, storage.DeleteLemma("lemma"))
+	_, err = storage.GetLemma("lemma")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestCachedMemoryTier(t *testing.T) {
+	oldLemmas := []*parser.Lemma{{Lemma: "old"}}
+	newLemmas := []*parser.Lemma{{Lemma: "new"}}
+	q := &mocks.QueryInterface{}
+	q.On("GetLemma", mock.Anything, "lemma").Return(oldLemmas, nil).Once()
+	q.On("GetLemma", mock.Anything, "lemma").Return(newLemmas, nil).Once()
+	cached := NewCached(q, store.NewMemory(0), &CachedConfig{
+		Memory: &MemoryCacheConfig{MaxEntries: 10},
+	})
+
+	// the first call fetches lemma, the second reads it from store, the third from memory
+	for i := 0; i < 3; i++ {
+		lemmas, err := cached.GetLemma(context.TODO(), "lemma")
+		assert.NoError(t, err)
+		assert.Equal(t, oldLemmas, lemmas)
+	}
+	assert.Equal(t, uint64(1), cached.Stats().Memory.Hits)
+
+	// refreshed record replaces the one in memory
+	lemmas, err := cached.GetLemma(WithRefresh(context.TODO()), "lemma")
+	assert.NoError(t, err)
+	assert.Equal(t, newLemmas, lemmas)
+	lemmas, err = cached.GetLemma(context.TODO(), "lemma")
+	assert.NoError(t, err)
+	assert.Equal(t, newLemmas, lemmas)
+	q.AssertExpectations(t)
+}
@@ -0,0 +1,213 @@
+package querier
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// CurrentSchemaVersion is schema version of queries and lemmas written by this version of camgo.
+// When CachedQuery, CachedLemma or parser.Lemma change, it should be incremented
+// and migration from the previous version should be added to migrations.
+const CurrentSchemaVersion = 1
+
+// migrations are upgrade steps of records, they are applied in order of From
+var migrations = []*Migration{
+	{
+		From:        0,
+		Description: "add schema version, content of records is the same",
+	},
+}
+
+// DocumentUpgrade changes JSON document of record in place
+type DocumentUpgrade func(doc map[string]interface{}) error
+
+// Migration upgrades records from schema version From to From+1.
+// Records are upgraded in form of JSON documents with the same keys as JSON encoded records.
+type Migration struct {
+	From        int
+	Description string
+	// Query and Lemma upgrade documents of queries and lemmas, nil means that documents don't change
+	Query DocumentUpgrade
+	Lemma DocumentUpgrade
+}
+
+// ErrNewerSchema is returned for records written by newer version of camgo
+var ErrNewerSchema = errors.New("record has newer schema")
+
+// MigrationError is returned when record can not be upgraded to current schema
+type MigrationError struct {
+	From int
+	To   int
+	Err  error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("can not upgrade record from schema %d to %d: %s", e.From, e.To, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Migrator upgrades records to its schema version
+type Migrator struct {
+	version int
+	steps   map[int]*Migration
+}
+
+// NewMigrator returns migrator to schema version. There should be a step from every previous version
+func NewMigrator(version int, steps ...*Migration) (*Migrator, error) {
+	m := &Migrator{
+		version: version,
+		steps:   make(map[int]*Migration, len(steps)),
+	}
+	for _, step := range steps {
+		if _, ok := m.steps[step.From]; ok {
+			return nil, fmt.Errorf("duplicate migration from schema %d", step.From)
+		}
+		m.steps[step.From] = step
+	}
+	for from := 0; from < version; from++ {
+		if _, ok := m.steps[from]; !ok {
+			return nil, fmt.Errorf("missing migration from schema %d", from)
+		}
+	}
+	return m, nil
+}
+
+var defaultMigrator = mustMigrator(NewMigrator(CurrentSchemaVersion, migrations...))
+
+func mustMigrator(m *Migrator, err error) *Migrator {
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Version returns schema version of upgraded records
+func (m *Migrator) Version() int {
+	return m.version
+}
+
+// upgrade applies steps to doc of record of type t with schema version from
+func (m *Migrator) upgrade(t keyType, doc map[string]interface{}, from int) error {
+	if from > m.version {
+		return &MigrationError{From: from, To: m.version, Err: ErrNewerSchema}
+	}
+	for version := from; version < m.version; version++ {
+		step := m.steps[version]
+		upgrade := step.Lemma
+		if t == queryKey {
+			upgrade = step.Query
+		}
+		if upgrade == nil {
+			continue
+		}
+		if err := upgrade(doc); err != nil {
+			return &MigrationError{From: from, To: m.version, Err: fmt.Errorf("%s: %w", step.Description, err)}
+		}
+	}
+	doc["SchemaVersion"] = m.version
+	return nil
+}
+
+func (s *Storage) migrator() *Migrator {
+	if s.Migrator != nil {
+		return s.Migrator
+	}
+	return defaultMigrator
+}
+
+// MigrationResult describes what happened with record during migration
+type MigrationResult struct {
+	// Key is query or lemmaID
+	Key string
+	// IsLemma is true for lemmas and false for queries
+	IsLemma bool
+	// From is schema version of record before migration
+	From int
+	// Upgraded is true if record had older schema
+	Upgraded bool
+	// Err is error of decoding, upgrading or storing record
+	Err error
+}
+
+// Migrate upgrades every query and lemma to current schema. If dryRun is true, storage is not changed.
+// report is called for every record.
+func (s *Storage) Migrate(dryRun bool, report func(*MigrationResult)) error {
+	for _, t := range []keyType{queryKey, lemmaKey} {
+		t := t
+		prefix := []byte{byte(t)}
+		err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+			result := &MigrationResult{
+				Key:     string(entry.Key[len(prefix):]),
+				IsLemma: t == lemmaKey,
+			}
+			var encode func() ([]byte, error)
+			var lemma *CachedLemma
+			if t == queryKey {
+				var query *CachedQuery
+				query, result.From, result.Err = decodeQuery(entry.Value, s.migrator())
+				encode = func() ([]byte, error) { return encodeQuery(query, s.Encoding) }
+			} else {
+				lemma, result.From, result.Err = decodeLemma(entry.Value, s.migrator())
+				encode = func() ([]byte, error) { return encodeLemma(lemma, s.Encoding) }
+			}
+			result.Upgraded = result.Err == nil && result.From != s.migrator().version
+			if result.Upgraded && !dryRun {
+				var index func(batch *writeBatch, ttl time.Duration) error
+				if lemma != nil && lemma.Error == "" {
+					// migration could change indexed fields
+					index = func(batch *writeBatch, ttl time.Duration) error {
+						return s.indexLemma(batch, result.Key, lemma.Lemmas, ttl)
+					}
+				}
+				result.Err = s.writeBack(entry, encode, index)
+			}
+			report(result)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("can not iterate records: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeBack replaces upgraded entry keeping its expiration time.
+// If index isn't nil, it adds index entries of the entry to the same batch
+func (s *Storage) writeBack(
+	entry *store.Entry,
+	encode func() ([]byte, error),
+	index func(batch *writeBatch, ttl time.Duration) error,
+) error {
+	ttl, alive := remainingTTL(entry)
+	if !alive {
+		return nil
+	}
+	data, err := encode()
+	if err != nil {
+		return err
+	}
+	batch := &writeBatch{}
+	batch.put(entry.Key, data, ttl)
+	if index != nil {
+		if err := index(batch, ttl); err != nil {
+			return err
+		}
+	}
+	return s.apply(batch)
+}
+
+// remainingTTL returns ttl with which entry should be written again to keep its expiration time.
+// Zero ttl means that entry never expires, false means that entry has already expired
+func remainingTTL(entry *store.Entry) (time.Duration, bool) {
+	if entry.ExpiresAt.IsZero() {
+		return 0, true
+	}
+	ttl := time.Until(entry.ExpiresAt)
+	return ttl, ttl > 0
+}
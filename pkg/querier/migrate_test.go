@@ -0,0 +1,191 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/vmihailenco/msgpack/v4"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// upperLemmaMigration is schema 2 for tests, in which lemmas are upper case
+var upperLemmaMigration = &Migration{
+	From:        1,
+	Description: "upper case lemmas",
+	Lemma: func(doc map[string]interface{}) error {
+		lemmas, _ := doc["Lemmas"].([]interface{})
+		for _, lemma := range lemmas {
+			lemmaDoc, ok := lemma.(map[string]interface{})
+			if !ok {
+				return errors.New("lemma is not an object")
+			}
+			word, _ := lemmaDoc["lemma"].(string)
+			lemmaDoc["lemma"] = strings.ToUpper(word)
+		}
+		return nil
+	},
+}
+
+func newTestMigrator(t *testing.T, steps ...*Migration) *Migrator {
+	m, err := NewMigrator(CurrentSchemaVersion+len(steps), append(append([]*Migration{}, migrations...), steps...)...)
+	if err != nil {
+		t.Fatalf("can not create migrator: %v", err)
+	}
+	return m
+}
+
+func TestNewMigrator(t *testing.T) {
+	_, err := NewMigrator(2, migrations...)
+	assert.Error(t, err, "migration from 1 is missing")
+	_, err = NewMigrator(1, append(migrations, migrations...)...)
+	assert.Error(t, err, "migration from 0 is duplicated")
+	m, err := NewMigrator(CurrentSchemaVersion, migrations...)
+	assert.NoError(t, err)
+	assert.Equal(t, CurrentSchemaVersion, m.Version())
+}
+
+func TestLazyMigration(t *testing.T) {
+	for name, encoding := range testEncodings {
+		encoding := encoding
+		t.Run(name, func(t *testing.T) {
+			cache := store.NewMemory(0)
+			old := &Storage{Store: cache, Encoding: encoding}
+			assert.NoError(t, old.PutLemmaWithTTL("hello", []*parser.Lemma{{Lemma: "hello"}}, nil, time.Hour))
+			assert.NoError(t, old.PutQuery("hello", "hello", nil, nil))
+
+			upgraded := &Storage{Store: cache, Encoding: encoding, Migrator: newTestMigrator(t, upperLemmaMigration)}
+			lemma, err := upgraded.GetLemma("hello")
+			assert.NoError(t, err)
+			assert.Equal(t, "HELLO", lemma.Lemmas[0].Lemma)
+			assert.Equal(t, 2, lemma.SchemaVersion)
+			query, err := upgraded.GetQuery("hello")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", query.LemmaID)
+
+			// upgraded record is written back with the same expiration time
+			entry, err := cache.Get(marshalKey("hello", lemmaKey))
+			assert.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Minute)
+			_, version, err := decodeLemma(entry.Value, upgraded.Migrator)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, version)
+		})
+	}
+}
+
+func TestDecodeBinaryV1(t *testing.T) {
+	payload, err := msgpack.Marshal(&lemmaRecordV1{
+		Lemmas: []*lemmaV1{{Lemma: "hello", Language: "british"}},
+		Error:  "",
+	})
+	assert.NoError(t, err)
+	data := append([]byte{formatMsgpackV1 << formatShift}, payload...)
+
+	lemma, version, err := decodeLemma(data, defaultMigrator)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+	assert.Equal(t, []*parser.Lemma{{Lemma: "hello", Language: "british"}}, lemma.Lemmas)
+	assert.Equal(t, CurrentSchemaVersion, lemma.SchemaVersion)
+}
+
+func TestMigrationErrors(t *testing.T) {
+	cache := store.NewMemory(0)
+	newer := &Storage{Store: cache, Migrator: newTestMigrator(t, upperLemmaMigration)}
+	assert.NoError(t, newer.PutLemma("newer", []*parser.Lemma{{Lemma: "newer"}}, nil))
+
+	current := &Storage{Store: cache}
+	_, err := current.GetLemma("newer")
+	assert.True(t, errors.Is(err, ErrNewerSchema))
+
+	broken := &Migration{
+		From:        1,
+		Description: "broken",
+		Lemma: func(doc map[string]interface{}) error {
+			return errors.New("broken")
+		},
+	}
+	assert.NoError(t, current.PutLemma("current", []*parser.Lemma{{Lemma: "current"}}, nil))
+	brokenStorage := &Storage{Store: cache, Migrator: newTestMigrator(t, broken)}
+	_, err = brokenStorage.GetLemma("current")
+	var migrationErr *MigrationError
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, 1, migrationErr.From)
+	assert.Equal(t, 2, migrationErr.To)
+}
+
+func TestStorageMigrate(t *testing.T) {
+	cache := store.NewMemory(0)
+	old := &Storage{Store: cache}
+	assert.NoError(t, old.PutLemma("a", []*parser.Lemma{{Lemma: "a"}}, nil))
+	assert.NoError(t, old.PutLemma("b", []*parser.Lemma{{Lemma: "b"}}, nil))
+	assert.NoError(t, old.PutQuery("a", "a", nil, nil))
+	assert.NoError(t, cache.Put(marshalKey("broken", lemmaKey), []byte("{broken"), 0))
+
+	storage := &Storage{Store: cache, Migrator: newTestMigrator(t, upperLemmaMigration)}
+	migrate := func(dryRun bool) (upgraded int, failed []string) {
+		err := storage.Migrate(dryRun, func(result *MigrationResult) {
+			switch {
+			case result.Err != nil:
+				failed = append(failed, result.Key)
+			case result.Upgraded:
+				upgraded++
+			}
+		})
+		assert.NoError(t, err)
+		return upgraded, failed
+	}
+	upgraded, failed := migrate(true)
+	assert.Equal(t, 3, upgraded)
+	assert.Equal(t, []string{"broken"}, failed)
+
+	upgraded, failed = migrate(false)
+	assert.Equal(t, 3, upgraded)
+	assert.Equal(t, []string{"broken"}, failed)
+
+	upgraded, _ = migrate(false)
+	assert.Equal(t, 0, upgraded, "all records are upgraded")
+	entry, err := cache.Get(marshalKey("b", lemmaKey))
+	assert.NoError(t, err)
+	lemma, version, err := decodeLemma(entry.Value, storage.Migrator)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.Equal(t, "B", lemma.Lemmas[0].Lemma)
+}
+
+func TestCachedRefetchesNewerSchema(t *testing.T) {
+	cache := store.NewMemory(0)
+	newer := &Storage{Store: cache, Migrator: newTestMigrator(t, upperLemmaMigration)}
+	assert.NoError(t, newer.PutLemma("hello", []*parser.Lemma{{Lemma: "HELLO"}}, nil))
+
+	assert.NoError(t, newer.PutQuery("hi", "hello", nil, nil))
+
+	q := &mocks.QueryInterface{}
+	q.On("GetLemma", mock.Anything, "hello").Return([]*parser.Lemma{{Lemma: "hello"}}, nil).Twice()
+	q.On("Search", mock.Anything, "hi").Return("hello", []string(nil), nil).Once()
+	cached := NewCached(q, cache, nil)
+	for i := 0; i < 2; i++ {
+		lemmas, err := cached.GetLemma(context.TODO(), "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", lemmas[0].Lemma)
+	}
+	lemmaID, _, err := cached.Search(context.TODO(), "hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", lemmaID)
+	q.AssertExpectations(t)
+
+	// records of newer schema are not downgraded
+	lemma, err := newer.GetLemma("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO", lemma.Lemmas[0].Lemma)
+	query, err := newer.GetQuery("hi")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, query.SchemaVersion)
+}
@@ -0,0 +1,85 @@
+package querier
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// QueryNormalization specifies how queries are changed before they are searched and cached,
+// so different spellings of the same query share one cache record
+type QueryNormalization struct {
+	// FoldCase converts query to lower case
+	FoldCase bool
+	// Whitespace trims query and replaces every run of whitespace with one space
+	Whitespace bool
+	// NFC composes query to Unicode normalization form C
+	NFC bool
+	// Apostrophes replaces typographic apostrophes and single quotes with ASCII apostrophe
+	Apostrophes bool
+}
+
+// DefaultQueryNormalization enables every normalization
+var DefaultQueryNormalization = QueryNormalization{
+	FoldCase:    true,
+	Whitespace:  true,
+	NFC:         true,
+	Apostrophes: true,
+}
+
+var apostropheReplacer = strings.NewReplacer(
+	"’", "'", // right single quotation mark
+	"‘", "'", // left single quotation mark
+	"ʼ", "'", // modifier letter apostrophe
+	"′", "'", // prime
+	"´", "'", // acute accent
+	"`", "'",
+)
+
+// Normalize returns normalized query. Nil normalization returns query as is
+func (n *QueryNormalization) Normalize(query string) string {
+	if n == nil {
+		return query
+	}
+	if n.NFC {
+		query = norm.NFC.String(query)
+	}
+	if n.Apostrophes {
+		query = apostropheReplacer.Replace(query)
+	}
+	if n.FoldCase {
+		query = strings.ToLower(query)
+	}
+	if n.Whitespace {
+		query = strings.Join(strings.Fields(query), " ")
+	}
+	return query
+}
+
+// ParseQueryNormalization parses "all", "none" or comma separated list of case, space, nfc and apostrophe
+func ParseQueryNormalization(s string) (*QueryNormalization, error) {
+	switch s {
+	case "all":
+		normalization := DefaultQueryNormalization
+		return &normalization, nil
+	case "none", "":
+		return nil, nil
+	}
+	normalization := &QueryNormalization{}
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "case":
+			normalization.FoldCase = true
+		case "space":
+			normalization.Whitespace = true
+		case "nfc":
+			normalization.NFC = true
+		case "apostrophe":
+			normalization.Apostrophes = true
+		default:
+			return nil, fmt.Errorf("unknown query normalization '%s'", name)
+		}
+	}
+	return normalization, nil
+}
@@ -0,0 +1,107 @@
+package querier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestQueryNormalization(t *testing.T) {
+	var none *QueryNormalization
+	assert.Equal(t, " Print ", none.Normalize(" Print "))
+
+	all := &DefaultQueryNormalization
+	testCases := map[string]string{
+		"print":               "print",
+		"Print":               "print",
+		" print \t":           "print",
+		"get   out":           "get out",
+		"print\u2019s":        "print's",
+		" Don\u2018t  PANIC ": "don't panic",
+		"rock `n' roll":       "rock 'n' roll",
+		"cafe\u0301":          "caf\u00e9",
+		"E\u0301TUDE":         "\u00e9tude",
+	}
+	for query, expected := range testCases {
+		assert.Equal(t, expected, all.Normalize(query), query)
+	}
+
+	onlyCase := &QueryNormalization{FoldCase: true}
+	assert.Equal(t, " print\u2019s ", onlyCase.Normalize(" Print\u2019s "))
+}
+
+func TestParseQueryNormalization(t *testing.T) {
+	normalization, err := ParseQueryNormalization("all")
+	assert.NoError(t, err)
+	assert.Equal(t, &DefaultQueryNormalization, normalization)
+	normalization, err = ParseQueryNormalization("none")
+	assert.NoError(t, err)
+	assert.Nil(t, normalization)
+	normalization, err = ParseQueryNormalization("case,space")
+	assert.NoError(t, err)
+	assert.Equal(t, &QueryNormalization{FoldCase: true, Whitespace: true}, normalization)
+	_, err = ParseQueryNormalization("case,unknown")
+	assert.Error(t, err)
+}
+
+func TestCachedNormalization(t *testing.T) {
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "print's").Return("print", []string(nil), nil).Once()
+	cached := NewCached(q, store.NewMemory(0), &CachedConfig{Normalization: &DefaultQueryNormalization})
+	for _, query := range []string{"print's", " Print\u2019s ", "PRINT'S"} {
+		lemmaID, _, err := cached.Search(context.TODO(), query)
+		assert.NoError(t, err)
+		assert.Equal(t, "print", lemmaID)
+	}
+	q.AssertExpectations(t)
+}
+
+func TestQueryAliases(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), &MemoryCacheConfig{MaxEntries: 10})
+	assert.NoError(t, storage.PutQuery("print", "print", nil, nil))
+	assert.NoError(t, storage.PutQuery("prints", "print", nil, nil))
+	assert.NoError(t, storage.PutQuery("printed", "print", nil, nil))
+	assert.NoError(t, storage.PutQuery("get out", "get-out", nil, nil))
+	assert.NoError(t, storage.PutQuery("prnt", "", []string{"print"}, ErrSuggestions))
+	assert.NoError(t, storage.PutLemma("print", nil, nil))
+	// warm memory cache, invalidation must reach it
+	_, err := storage.GetQuery("print")
+	assert.NoError(t, err)
+
+	aliases, err := storage.Aliases("print")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"print", "printed", "prints"}, aliases)
+
+	t.Run("query points at another lemma", func(t *testing.T) {
+		assert.NoError(t, storage.PutQuery("printed", "printed", nil, nil))
+		aliases, err := storage.Aliases("print")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"print", "prints"}, aliases)
+	})
+	t.Run("deleted query", func(t *testing.T) {
+		assert.NoError(t, storage.DeleteQuery("prints"))
+		aliases, err := storage.Aliases("print")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"print"}, aliases)
+		_, err = storage.Store.Get(aliasRecordKey("print", "prints"))
+		assert.Equal(t, store.ErrNotFound, err)
+	})
+	t.Run("deleted lemma", func(t *testing.T) {
+		assert.NoError(t, storage.DeleteLemma("print"))
+		_, err := storage.GetQuery("print")
+		assert.Equal(t, store.ErrNotFound, err)
+		for _, query := range []string{"printed", "get out", "prnt"} {
+			_, err := storage.GetQuery(query)
+			assert.NoError(t, err, query)
+		}
+		assert.NoError(t, storage.Store.Iterate(aliasPrefix("print"), func(entry *store.Entry) error {
+			t.Errorf("unexpected alias %q", entry.Key)
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,321 @@
+package querier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+const (
+	// PeerBasePath is path under which PeerHandler should be served
+	PeerBasePath = "/_camgo/"
+
+	peerQueryPath = PeerBasePath + "query"
+	peerLemmaPath = PeerBasePath + "lemma"
+
+	defaultPeerReplicas = 50
+	defaultPeerTimeout  = time.Second * 10
+	defaultPeerRetry    = time.Second * 30
+)
+
+// PeerConfig specifies peers that share cache. Every key has one owner peer chosen by consistent hashing,
+// other peers ask the owner before they fetch key from dictionary, so every key is fetched only once.
+// Every peer should have the same list of peers, otherwise keys are fetched by several owners.
+type PeerConfig struct {
+	// Self is base URL of this instance as it's listed in Peers, like http://10.0.0.1:8080.
+	// Empty Self means that instance only asks peers and owns no keys
+	Self string
+	// Peers are base URLs of every instance including Self, PeerHandler is served under PeerBasePath of them
+	Peers []string
+	// Replicas is number of points of every peer on hash ring, zero means 50
+	Replicas int
+	// Client makes requests to peers, nil means client with 10 seconds timeout.
+	// Owner that doesn't answer in time is busy, its key is fetched from dictionary, but it's asked again next time
+	Client *http.Client
+	// RetryAfter specifies how long peer that is down is not asked, meanwhile its keys are fetched from dictionary.
+	// Zero value means 30 seconds
+	RetryAfter time.Duration
+}
+
+// LoadPeers reads base URLs of peers from file, one per line. Empty lines and lines starting with # are skipped
+func LoadPeers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var peers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// HashRing assigns keys to peers by consistent hashing, so adding or removing peer moves only its keys
+type HashRing struct {
+	hashes []uint32
+	peers  map[uint32]string
+}
+
+// NewHashRing places every peer at replicas points of ring, zero replicas means 50
+func NewHashRing(peers []string, replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultPeerReplicas
+	}
+	ring := &HashRing{peers: make(map[uint32]string, len(peers)*replicas)}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			ring.hashes = append(ring.hashes, hash)
+			ring.peers[hash] = peer
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+	return ring
+}
+
+// Owner returns peer that owns key or empty string if ring has no peers
+func (r *HashRing) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
+
+// PeerError is error of query or lemma that owner peer got from dictionary
+type PeerError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *PeerError) Error() string {
+	return e.Message
+}
+
+// peerResponse is result of query or lemma sent by owner peer
+type peerResponse struct {
+	LemmaID     string          `json:"lemma_id,omitempty"`
+	Suggestions []string        `json:"suggestions,omitempty"`
+	Lemmas      []*parser.Lemma `json:"lemmas,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	ErrorKind   ErrorKind       `json:"error_kind,omitempty"`
+}
+
+func (r *peerResponse) setError(err error) {
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorKind = ClassifyError(err)
+	}
+}
+
+func (r *peerResponse) err() error {
+	switch {
+	case r.Error == "":
+		return nil
+	case r.ErrorKind == ErrorKindNotFound:
+		return restoreError(r.Error)
+	default:
+		return &PeerError{Kind: r.ErrorKind, Message: r.Error}
+	}
+}
+
+type peerRequestKey struct{}
+
+// fromPeer reports if request is made by another peer, such requests are never forwarded again
+func fromPeer(ctx context.Context) bool {
+	value, _ := ctx.Value(peerRequestKey{}).(bool)
+	return value
+}
+
+// peerPool asks owner peers for records
+type peerPool struct {
+	self       string
+	ring       *HashRing
+	client     *http.Client
+	retryAfter time.Duration
+
+	mu sync.Mutex
+	// failedAt are times when peers were found down
+	failedAt map[string]time.Time
+}
+
+func newPeerPool(config *PeerConfig) *peerPool {
+	pool := &peerPool{
+		self:       config.Self,
+		ring:       NewHashRing(config.Peers, config.Replicas),
+		client:     config.Client,
+		retryAfter: config.RetryAfter,
+		failedAt:   make(map[string]time.Time),
+	}
+	if pool.client == nil {
+		pool.client = &http.Client{Timeout: defaultPeerTimeout}
+	}
+	if pool.retryAfter == 0 {
+		pool.retryAfter = defaultPeerRetry
+	}
+	return pool
+}
+
+// owner returns peer that should be asked for key, or empty string if record should be fetched from dictionary
+func (p *peerPool) owner(ctx context.Context, key []byte) string {
+	if p == nil || fromPeer(ctx) {
+		return ""
+	}
+	owner := p.ring.Owner(string(key))
+	if owner == p.self {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if failedAt, ok := p.failedAt[owner]; ok && time.Since(failedAt) < p.retryAfter {
+		return ""
+	}
+	return owner
+}
+
+// search asks owner of query. It returns false if query should be fetched from dictionary
+func (p *peerPool) search(ctx context.Context, query string) (lemmaID string, suggestions []string, ok bool, err error) {
+	owner := p.owner(ctx, marshalKey(query, queryKey))
+	if owner == "" {
+		return "", nil, false, nil
+	}
+	response, err := p.get(ctx, owner, peerQueryPath, url.Values{"q": {query}})
+	if err != nil {
+		return "", nil, !p.failed(ctx, owner, err), err
+	}
+	return response.LemmaID, response.Suggestions, true, response.err()
+}
+
+// getLemma asks owner of lemmaID. It returns false if lemma should be fetched from dictionary
+func (p *peerPool) getLemma(ctx context.Context, lemmaID string) (lemmas []*parser.Lemma, ok bool, err error) {
+	owner := p.owner(ctx, marshalKey(lemmaID, lemmaKey))
+	if owner == "" {
+		return nil, false, nil
+	}
+	response, err := p.get(ctx, owner, peerLemmaPath, url.Values{"id": {lemmaID}})
+	if err != nil {
+		return nil, !p.failed(ctx, owner, err), err
+	}
+	return response.Lemmas, true, response.err()
+}
+
+// failed reports if request to peer failed not because it was cancelled by caller.
+// Peer is remembered as down unless it's just busy, see isPeerBusy
+func (p *peerPool) failed(ctx context.Context, peer string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if isPeerBusy(err) {
+		return true
+	}
+	p.mu.Lock()
+	p.failedAt[peer] = time.Now()
+	p.mu.Unlock()
+	return true
+}
+
+// isPeerBusy reports if peer is up, but didn't answer in time or rejected request because it's overloaded
+func isPeerBusy(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusServiceUnavailable
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (p *peerPool) get(ctx context.Context, peer, path string, params url.Values) (*peerResponse, error) {
+	if refreshRequested(ctx) {
+		params.Set("refresh", "1")
+	}
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(peer, "/")+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: response.StatusCode}
+	}
+	var result peerResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("can not decode response of peer: %w", err)
+	}
+	return &result, nil
+}
+
+// PeerHandler returns handler that answers requests of other peers from cache of c.
+// Records missing in cache are fetched from dictionary and cached. It should be served under PeerBasePath.
+// Requests are not authenticated and any client can make c fetch records again, so handler must be
+// reachable only by trusted peers. Requests of peers are executed with PriorityBatch
+func (c *Cached) PeerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerQueryPath, func(w http.ResponseWriter, r *http.Request) {
+		ctx := peerContext(r)
+		lemmaID, suggestions, _, err := c.search(ctx, r.URL.Query().Get("q"))
+		response := &peerResponse{LemmaID: lemmaID, Suggestions: suggestions}
+		writePeerResponse(ctx, w, response, err)
+	})
+	mux.HandleFunc(peerLemmaPath, func(w http.ResponseWriter, r *http.Request) {
+		ctx := peerContext(r)
+		lemmas, _, err := c.getLemma(ctx, r.URL.Query().Get("id"))
+		response := &peerResponse{Lemmas: lemmas}
+		writePeerResponse(ctx, w, response, err)
+	})
+	return mux
+}
+
+// peerContext returns context of request of peer with refresh of the peer.
+// Peers can't raise priority of their requests above local batch jobs
+func peerContext(r *http.Request) context.Context {
+	ctx := context.WithValue(r.Context(), peerRequestKey{}, true)
+	if r.URL.Query().Get("refresh") != "" {
+		ctx = WithRefresh(ctx)
+	}
+	return WithPriority(ctx, PriorityBatch)
+}
+
+func writePeerResponse(ctx context.Context, w http.ResponseWriter, response *peerResponse, err error) {
+	if (isContextError(err) && ctx.Err() != nil) || isOverloadError(err) {
+		// request was abandoned or rejected, it says nothing about record
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	response.setError(err)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
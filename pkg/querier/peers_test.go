@@ -0,0 +1,173 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestHashRing(t *testing.T) {
+	assert.Equal(t, "", NewHashRing(nil, 0).Owner("key"))
+
+	peers := []string{"http://a", "http://b", "http://c"}
+	ring := NewHashRing(peers, 0)
+	owned := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners[key] = ring.Owner(key)
+		owned[owners[key]]++
+	}
+	for _, peer := range peers {
+		assert.True(t, owned[peer] > 100, "peer %s owns %d keys", peer, owned[peer])
+	}
+
+	// only keys of removed peer move
+	ring = NewHashRing(peers[:2], 0)
+	for key, owner := range owners {
+		if owner != "http://c" {
+			assert.Equal(t, owner, ring.Owner(key))
+		}
+	}
+}
+
+func TestLoadPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camgo-peers")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "peers")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# team\nhttp://a:8080\n\n  http://b:8080  \n"), 0600))
+
+	peers, err := LoadPeers(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080"}, peers)
+	_, err = LoadPeers(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+// testPeer is in-process camgo instance that shares cache with other peers
+type testPeer struct {
+	server  *httptest.Server
+	handler http.Handler
+	cached  *Cached
+}
+
+// startPeers starts count peers that use querier for keys they own
+func startPeers(t *testing.T, q QueryInterface, count int) []*testPeer {
+	peers := make([]*testPeer, count)
+	urls := make([]string, count)
+	for i := range peers {
+		peer := &testPeer{}
+		peer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer.handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(peer.server.Close)
+		peers[i], urls[i] = peer, peer.server.URL
+	}
+	for _, peer := range peers {
+		peer.cached = NewCached(q, store.NewMemory(0), &CachedConfig{
+			Peers: &PeerConfig{Self: peer.server.URL, Peers: urls},
+		})
+		peer.handler = peer.cached.PeerHandler()
+	}
+	return peers
+}
+
+func TestCachedPeers(t *testing.T) {
+	expectedLemmas := []*parser.Lemma{{Lemma: "hello"}}
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "hello").Return("hello_id", []string(nil), nil).Once()
+	q.On("GetLemma", mock.Anything, "hello_id").Return(expectedLemmas, nil).Once()
+	q.On("Search", mock.Anything, "helo").Return("", []string{"hello"}, ErrSuggestions).Once()
+	q.On("Search", mock.Anything, "broken").Return("", []string(nil), &StatusError{StatusCode: http.StatusNotFound}).Once()
+	peers := startPeers(t, q, 3)
+
+	// every record is fetched from dictionary only once by its owner
+	for _, peer := range peers {
+		result, err := peer.cached.Lookup(context.TODO(), "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello_id", result.LemmaID)
+		assert.Equal(t, expectedLemmas, result.Lemmas)
+
+		result, err = peer.cached.Lookup(context.TODO(), "helo")
+		assert.True(t, errors.Is(err, ErrSuggestions))
+		assert.Equal(t, []string{"hello"}, result.Suggestions)
+
+		_, err = peer.cached.Lookup(context.TODO(), "broken")
+		assert.EqualError(t, err, "unexpected response code: 404")
+	}
+	q.AssertExpectations(t)
+}
+
+func TestCachedPeerDown(t *testing.T) {
+	expectedLemmas := []*parser.Lemma{{Lemma: "hello"}}
+	q := &mocks.QueryInterface{}
+	peers := startPeers(t, q, 2)
+	ring := NewHashRing([]string{peers[0].server.URL, peers[1].server.URL}, 0)
+	owner, other := peers[0], peers[1]
+	if ring.Owner(string(marshalKey("hello_id", lemmaKey))) != owner.server.URL {
+		owner, other = other, owner
+	}
+	owner.server.Close()
+
+	// lemma of failed owner is fetched from dictionary
+	q.On("GetLemma", mock.Anything, "hello_id").Return(expectedLemmas, nil).Once()
+	lemmas, err := other.cached.GetLemma(context.TODO(), "hello_id")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedLemmas, lemmas)
+	q.AssertExpectations(t)
+}
+
+func TestPeerBusy(t *testing.T) {
+	// isDown asks owner behind handler for lemma and reports if owner is remembered as down
+	isDown := func(t *testing.T, handler http.HandlerFunc, client *http.Client) bool {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		pool := newPeerPool(&PeerConfig{Peers: []string{server.URL}, Client: client})
+		_, ok, err := pool.getLemma(context.TODO(), "hello_id")
+		assert.False(t, ok, "lemma must be fetched from dictionary")
+		assert.Error(t, err)
+		return pool.owner(context.TODO(), marshalKey("hello_id", lemmaKey)) == ""
+	}
+	t.Run("overloaded", func(t *testing.T) {
+		q := &mocks.QueryInterface{}
+		q.On("GetLemma", mock.Anything, "hello_id").Return([]*parser.Lemma(nil), ErrQueueFull).Once()
+		owner := NewCached(q, store.NewMemory(0), nil)
+		assert.False(t, isDown(t, owner.PeerHandler().ServeHTTP, nil))
+		q.AssertExpectations(t)
+	})
+	t.Run("slow", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 100)
+		}
+		assert.False(t, isDown(t, handler, &http.Client{Timeout: time.Millisecond * 20}))
+	})
+	t.Run("broken", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		assert.True(t, isDown(t, handler, nil))
+	})
+}
+
+func TestPeerContext(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, peerLemmaPath+"?id=hello&refresh=1&priority=1", nil)
+	ctx := peerContext(request)
+	assert.True(t, fromPeer(ctx))
+	assert.True(t, refreshRequested(ctx))
+	assert.Equal(t, PriorityBatch, priorityFromContext(ctx))
+}
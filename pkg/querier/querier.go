@@ -1,9 +1,11 @@
 package querier
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
@@ -12,7 +14,8 @@ import (
 	"time"
 
 	"github.com/darkclainer/camgo/pkg/parser"
-	"github.com/gammazero/workerpool"
+	"github.com/darkclainer/camgo/pkg/transport"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -28,6 +31,35 @@ var (
 	ErrSuggestions  = errors.New("suggestions exist")
 )
 
+// StatusError is returned when remote host responds with unexpected status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response code: %d", e.StatusCode)
+}
+
+// ParseError is returned when page can not be parsed
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Page is raw html page of lemma
+type Page struct {
+	URL       string
+	FetchedAt time.Time
+	Body      []byte
+}
+
 type Config struct {
 	// ExtraHeader specifies what header will be added to each request
 	ExtraHeader map[string]string
@@ -39,13 +71,34 @@ type Config struct {
 	// MaxWorkers specifies how many worker parse html content of page
 	// Zero value mean that it will be equal to number of logical CPU
 	MaxWorkers int
+	// MaxQueue specifies how many pages can wait for parse worker.
+	// If queue is full, request fails with ErrQueueFull. Zero value means no limit
+	MaxQueue int
+	// RateLimit specifies how many requests per second can be sent to remote host.
+	// Zero value means no limit
+	RateLimit float64
+	// RateBurst specifies how many requests can be sent at once when RateLimit is set.
+	// Zero value means one
+	RateBurst int
+	// Middlewares wrap transport of client. The first middleware sees request first.
+	// See package transport for built-in middlewares
+	Middlewares []transport.Middleware
+	// Jar specifies cookie jar for requests, by default cookies are not stored
+	Jar http.CookieJar
+	// Recorder saves every request to remote host with its response.
+	// It's the last middleware, so it records requests as they are sent.
+	// Use transport.Replayer as transport of client to replay recordings
+	Recorder *transport.Recorder
 }
 
 type Querier struct {
 	client *http.Client
 	config *Config
-	pool   *workerpool.WorkerPool
+	pool   *parseScheduler
 	p      Parser
+
+	lemmaFlights flightGroup
+	queryFlights flightGroup
 }
 
 func NewQuerier(client *http.Client, p Parser, config *Config) *Querier {
@@ -59,15 +112,22 @@ func NewQuerier(client *http.Client, p Parser, config *Config) *Querier {
 		config.Host = defaultHost
 	}
 	if config.Protocol == "" {
-		config.Host = defaultProtocol
+		config.Protocol = defaultProtocol
 	}
 	if config.MaxWorkers < 1 { // nolint:gomnd // if number not specified
 		config.MaxWorkers = runtime.NumCPU()
 	}
+	var limiter *rate.Limiter
+	if config.RateLimit > 0 {
+		if config.RateBurst < 1 {
+			config.RateBurst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(config.RateLimit), config.RateBurst)
+	}
 	return &Querier{
-		client: client,
+		client: wrapClient(client, config, limiter),
 		config: config,
-		pool:   workerpool.New(config.MaxWorkers),
+		pool:   newParseScheduler(config.MaxWorkers, config.MaxQueue),
 		p:      p,
 	}
 }
@@ -81,26 +141,95 @@ func getDefaultQuerierClient() *http.Client {
 	}
 }
 
+// wrapClient returns copy of client with middlewares, rate limiter and cookie jar from config,
+// so client passed by user stays untouched. Limiter is placed after middlewares, so every retry is limited
+func wrapClient(client *http.Client, config *Config, limiter *rate.Limiter) *http.Client {
+	middlewares := config.Middlewares
+	if limiter != nil {
+		middlewares = append(middlewares[:len(middlewares):len(middlewares)], transport.RateLimit(limiter))
+	}
+	if config.Recorder != nil {
+		middlewares = append(middlewares[:len(middlewares):len(middlewares)], config.Recorder.Middleware())
+	}
+	if len(middlewares) == 0 && config.Jar == nil {
+		return client
+	}
+	wrapped := *client
+	wrapped.Transport = transport.Chain(client.Transport, middlewares...)
+	if config.Jar != nil {
+		wrapped.Jar = config.Jar
+	}
+	return &wrapped
+}
+
+// GetLemma returns lemmas for specified lemmaID.
+// Concurrent calls with the same lemmaID share one request and its result, so returned lemmas must not be modified.
 func (q *Querier) GetLemma(ctx context.Context, lemmaID string) ([]*parser.Lemma, error) {
-	response, err := q.get(ctx, q.newLemmaURL(lemmaID), http.StatusOK)
+	lemmas, _, err := q.GetLemmaPage(ctx, lemmaID)
+	return lemmas, err
+}
+
+// GetLemmaPage returns lemmas for specified lemmaID and the page they were parsed from.
+// Page is returned even if it can not be parsed. Lemmas and page are shared like in GetLemma.
+func (q *Querier) GetLemmaPage(ctx context.Context, lemmaID string) ([]*parser.Lemma, *Page, error) {
+	value, err := q.lemmaFlights.Do(ctx, lemmaID, func(ctx context.Context) interface{} {
+		lemmas, page, err := q.getLemma(ctx, lemmaID)
+		return &lemmaResult{lemmas: lemmas, page: page, err: err}
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get lemma: %w", err)
+		return nil, nil, err
+	}
+	result := value.(*lemmaResult)
+	return result.lemmas, result.page, result.err
+}
+
+func (q *Querier) getLemma(ctx context.Context, lemmaID string) ([]*parser.Lemma, *Page, error) {
+	lemmaURL := q.newLemmaURL(lemmaID)
+	response, err := q.get(ctx, lemmaURL, http.StatusOK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get lemma: %w", err)
 	}
 	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read lemma page: %w", err)
+	}
+	page := &Page{
+		URL:       lemmaURL,
+		FetchedAt: time.Now(),
+		Body:      body,
+	}
 	var lemmas []*parser.Lemma
+	var parseErr error
 	// Use pool here, because it's heavy cpu bound task
-	q.pool.SubmitWait(func() {
-		lemmas, err = q.p.ParseLemma(response.Body)
+	err = q.pool.Do(ctx, func() {
+		lemmas, parseErr = q.p.ParseLemma(bytes.NewReader(body))
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to schedule parse: %w", err)
+	}
+	if parseErr != nil {
+		return nil, page, &ParseError{Err: parseErr}
 	}
-	return lemmas, nil
+	return lemmas, page, nil
 }
 
 // Search returns lemmaID if found something
-// Also it can return ErrLemmaNotFound error if there is some suggestions
+// Also it can return ErrLemmaNotFound error if there is some suggestions.
+// Concurrent calls with the same query share one request and its result, so suggestions must not be modified.
 func (q *Querier) Search(ctx context.Context, query string) (lemmadID string, suggestions []string, err error) {
+	value, err := q.queryFlights.Do(ctx, query, func(ctx context.Context) interface{} {
+		lemmaID, suggestions, err := q.search(ctx, query)
+		return &searchResult{lemmaID: lemmaID, suggestions: suggestions, err: err}
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	result := value.(*searchResult)
+	return result.lemmaID, result.suggestions, result.err
+}
+
+func (q *Querier) search(ctx context.Context, query string) (lemmadID string, suggestions []string, err error) {
 	redirect, err := q.getSearch(ctx, q.newSearchURL(query))
 	if err != nil {
 		return "", nil, fmt.Errorf("can not perform search: %w", err)
@@ -142,11 +271,15 @@ func (q *Querier) getSuggestions(ctx context.Context, urlSuggestions string) ([]
 	}
 	defer response.Body.Close()
 	var suggestions []string
-	q.pool.SubmitWait(func() {
-		suggestions, err = q.p.ParseSuggestion(response.Body)
+	var parseErr error
+	err = q.pool.Do(ctx, func() {
+		suggestions, parseErr = q.p.ParseSuggestion(response.Body)
 	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to schedule parse: %w", err)
+	}
+	if parseErr != nil {
+		return nil, &ParseError{Err: parseErr}
 	}
 	return suggestions, nil
 }
@@ -162,7 +295,7 @@ func (q *Querier) get(ctx context.Context, urlGet string, expectedStatus int) (*
 	}
 	if response.StatusCode != expectedStatus {
 		response.Body.Close()
-		return nil, fmt.Errorf("unexpected response code: %d", response.StatusCode)
+		return nil, &StatusError{StatusCode: response.StatusCode}
 	}
 	return response, err
 }
@@ -205,6 +338,6 @@ func (q *Querier) newRequest(ctx context.Context, urlRequest string) (*http.Requ
 
 func (q *Querier) Close(ctx context.Context) error {
 	q.client.CloseIdleConnections()
-	q.pool.StopWait()
+	q.pool.Stop()
 	return nil
 }
@@ -5,15 +5,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
 
 	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/transport"
 )
 
 func errorRequestf(t *testing.T, w http.ResponseWriter, format string, args ...interface{}) {
@@ -264,3 +269,98 @@ func TestQuerierGetLemma(t *testing.T) {
 		})
 	}
 }
+
+func TestQuerierRateLimit(t *testing.T) {
+	lemmaFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			err := json.NewEncoder(w).Encode([]*parser.Lemma{})
+			assert.NoError(t, err)
+		},
+	}
+	querier, clean := newTestQuerier(t, nil, nil, lemmaFn)
+	defer clean()
+	querier.client = wrapClient(querier.client, &Config{}, rate.NewLimiter(1, 1))
+
+	_, err := querier.GetLemma(context.TODO(), "hello")
+	assert.NoError(t, err)
+	// next token will be available only after a second
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = querier.GetLemma(ctx, "hello")
+	assert.Error(t, err)
+}
+
+func TestQuerierMiddlewares(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "middleware", r.Header.Get("X-Test"))
+		err := json.NewEncoder(w).Encode([]*parser.Lemma{})
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	client := server.Client()
+	originalTransport := client.Transport
+	querier := NewQuerier(client, &JSONParser{}, &Config{
+		Host:     server.Listener.Addr().String(),
+		Protocol: "http",
+		Middlewares: []transport.Middleware{
+			transport.Header(map[string]string{"X-Test": "middleware"}),
+		},
+	})
+	defer querier.Close(context.TODO())
+
+	_, err := querier.GetLemma(context.TODO(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, originalTransport, client.Transport, "client passed to querier must not be changed")
+}
+
+func TestQuerierRecordReplay(t *testing.T) {
+	page, err := ioutil.ReadFile("../parser/testdata/html/to-begin-with.html")
+	if err != nil {
+		t.Fatalf("can not read html page: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "camgo-session")
+	if err != nil {
+		t.Fatalf("can not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queryFn := map[string]http.HandlerFunc{
+		"to begin with": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, path.Join(lemmaPath, "to-begin-with"), http.StatusFound)
+		},
+	}
+	lemmaFn := map[string]http.HandlerFunc{
+		"to-begin-with": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(page)
+		},
+	}
+	querier, clean := newTestQuerier(t, queryFn, nil, lemmaFn)
+	recorder, err := transport.NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("can not create recorder: %v", err)
+	}
+	config := *querier.config
+	config.Recorder = recorder
+	recording := NewQuerier(nil, &HTMLParser{}, &config)
+	recorded, err := recording.Lookup(context.TODO(), "to begin with")
+	clean()
+	_ = recording.Close(context.TODO())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	interactions, err := transport.LoadInteractions(dir)
+	if err != nil {
+		t.Fatalf("can not load session: %v", err)
+	}
+	client := getDefaultQuerierClient()
+	client.Transport = transport.NewReplayer(interactions, transport.MatchStrict)
+	config.Recorder = nil
+	replaying := NewQuerier(client, &HTMLParser{}, &config)
+	defer replaying.Close(context.TODO())
+	replayed, err := replaying.Lookup(context.TODO(), "to begin with")
+	assert.NoError(t, err)
+	assert.Equal(t, "to-begin-with", replayed.LemmaID)
+	assert.NotEmpty(t, replayed.Lemmas)
+	assert.Equal(t, recorded.Lemmas, replayed.Lemmas)
+}
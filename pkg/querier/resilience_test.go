@@ -0,0 +1,146 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/transport"
+)
+
+// newChaosQuerier returns querier for test server with specified middlewares
+// and counter of requests that reached server
+func newChaosQuerier(t *testing.T, middlewares ...transport.Middleware) (*Querier, *int32, func()) {
+	var hits int32
+	queryFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			http.Redirect(w, r, path.Join(lemmaPath, "hello"), http.StatusFound)
+		},
+	}
+	lemmaFn := map[string]http.HandlerFunc{
+		"hello": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			err := json.NewEncoder(w).Encode([]*parser.Lemma{{Lemma: "hello"}})
+			assert.NoError(t, err)
+		},
+	}
+	server, clean := newTestQuerier(t, queryFn, nil, lemmaFn)
+	config := *server.config
+	config.Middlewares = middlewares
+	querier := NewQuerier(server.client, &JSONParser{}, &config)
+	return querier, &hits, func() {
+		_ = querier.Close(context.TODO())
+		clean()
+	}
+}
+
+func TestResilienceTimeout(t *testing.T) {
+	querier, _, clean := newChaosQuerier(t, transport.Chaos(&transport.ChaosConfig{
+		Latency: transport.ConstantLatency(time.Second),
+	}))
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	started := time.Now()
+	_, err := querier.Lookup(ctx, "hello")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.True(t, time.Since(started) < time.Second/2, "lookup must not wait for slow response")
+}
+
+func TestResilienceRetries(t *testing.T) {
+	script := []transport.Fault{
+		{Reset: true},
+		{StatusCode: http.StatusTooManyRequests},
+		{},
+		{StatusCode: http.StatusServiceUnavailable},
+	}
+	t.Run("with retries", func(t *testing.T) {
+		querier, hits, clean := newChaosQuerier(t,
+			transport.Retry(&transport.RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond, RateLimitedBackoff: time.Millisecond}),
+			transport.Chaos(&transport.ChaosConfig{Script: script}),
+		)
+		defer clean()
+		result, err := querier.Lookup(context.TODO(), "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", result.LemmaID)
+		assert.Equal(t, int32(2), atomic.LoadInt32(hits))
+	})
+	t.Run("without retries", func(t *testing.T) {
+		querier, hits, clean := newChaosQuerier(t,
+			transport.Chaos(&transport.ChaosConfig{Script: script}),
+		)
+		defer clean()
+		_, err := querier.Lookup(context.TODO(), "hello")
+		assert.True(t, errors.Is(err, transport.ErrInjectedReset), "unexpected error: %v", err)
+		_, err = querier.Lookup(context.TODO(), "hello")
+		assert.Error(t, err)
+		assert.Equal(t, int32(0), atomic.LoadInt32(hits))
+	})
+}
+
+func TestResilienceBrokenResponses(t *testing.T) {
+	t.Run("truncated body", func(t *testing.T) {
+		querier, _, clean := newChaosQuerier(t, transport.Chaos(&transport.ChaosConfig{
+			Script: []transport.Fault{{}, {Truncate: true}},
+		}))
+		defer clean()
+		_, err := querier.Lookup(context.TODO(), "hello")
+		assert.Error(t, err)
+	})
+	t.Run("wrong redirect", func(t *testing.T) {
+		querier, _, clean := newChaosQuerier(t, transport.Chaos(&transport.ChaosConfig{
+			Script: []transport.Fault{{Location: "/somewhere/else"}},
+		}))
+		defer clean()
+		_, _, err := querier.Search(context.TODO(), "hello")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "uknown redirect")
+		}
+	})
+}
+
+func TestResilienceNegativeCaching(t *testing.T) {
+	storage := getStorage(t)
+	t.Run("errors are cached", func(t *testing.T) {
+		querier, hits, clean := newChaosQuerier(t, transport.Chaos(&transport.ChaosConfig{
+			Script: []transport.Fault{{}, {StatusCode: http.StatusTooManyRequests}},
+		}))
+		defer clean()
+		cached := NewCached(querier, storage.Store, nil)
+		_, err := cached.Lookup(context.TODO(), "hello")
+		assert.Error(t, err)
+		result, err := cached.Lookup(context.TODO(), "hello")
+		assert.EqualError(t, err, "failed to get lemma: unexpected response code: 429")
+		assert.True(t, result.Cached)
+		assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+	})
+	t.Run("timeouts are not cached", func(t *testing.T) {
+		querier, _, clean := newChaosQuerier(t, transport.Chaos(&transport.ChaosConfig{
+			Script: []transport.Fault{{Delay: time.Second}},
+		}))
+		defer clean()
+		cached := NewCached(querier, storage.Store, nil)
+		finished := make(chan struct{})
+		cached.queryFlights.finished = func(string) {
+			close(finished)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+		_, _, err := cached.Search(ctx, "timeout")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+		// wait until flight is finished and result is possibly stored
+		<-finished
+		_, err = storage.GetQuery("timeout")
+		assert.Error(t, err, "timeout must not be cached")
+	})
+}
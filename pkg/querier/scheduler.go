@@ -0,0 +1,171 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrQueueFull        = errors.New("parse queue is full")
+	ErrSchedulerStopped = errors.New("parse scheduler is stopped")
+)
+
+// Priority specifies order in which parse jobs are executed.
+// Jobs with higher priority are executed first
+type Priority int
+
+const (
+	// PriorityBatch is for background jobs like prefetching
+	PriorityBatch Priority = iota
+	// PriorityInteractive is for jobs someone is waiting for. It's default priority
+	PriorityInteractive
+	priorityCount
+)
+
+type priorityKey struct{}
+
+// WithPriority returns context with which parse jobs will be executed with specified priority.
+// Caller that joins request already made by another caller raises priority of the request,
+// but parse job that is already waiting in queue keeps priority with which it was queued
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	priority, ok := ctx.Value(priorityKey{}).(Priority)
+	if !ok || priority < 0 || priority >= priorityCount {
+		return PriorityInteractive
+	}
+	return priority
+}
+
+type parseJob struct {
+	fn   func()
+	done chan struct{}
+}
+
+// parseScheduler executes cpu bound parse jobs on fixed number of workers.
+// Jobs wait in bounded queue, with separate FIFO for every priority.
+type parseScheduler struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queues   [priorityCount][]*parseJob
+	size     int
+	maxQueue int
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// newParseScheduler starts workers. Zero maxQueue means that queue is unbounded
+func newParseScheduler(workers, maxQueue int) *parseScheduler {
+	s := &parseScheduler{
+		maxQueue: maxQueue,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Do queues fn with priority from ctx and waits until it's executed.
+// If ctx is done while fn is still in queue, fn is removed and ctx error is returned.
+// fn that already started is always waited for.
+func (s *parseScheduler) Do(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	job := &parseJob{
+		fn:   fn,
+		done: make(chan struct{}),
+	}
+	priority := priorityFromContext(ctx)
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrSchedulerStopped
+	}
+	if s.maxQueue > 0 && s.size >= s.maxQueue {
+		s.mu.Unlock()
+		return ErrQueueFull
+	}
+	s.queues[priority] = append(s.queues[priority], job)
+	s.size++
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		if s.remove(priority, job) {
+			return ctx.Err()
+		}
+		// job is executing, fn may use caller's data, so we must wait
+		<-job.done
+		return nil
+	}
+}
+
+// remove removes job from queue and reports if it was there
+func (s *parseScheduler) remove(priority Priority, job *parseJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[priority]
+	for i := range queue {
+		if queue[i] == job {
+			s.queues[priority] = append(queue[:i], queue[i+1:]...)
+			s.size--
+			return true
+		}
+	}
+	return false
+}
+
+func (s *parseScheduler) work() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for s.size == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.size == 0 {
+			// scheduler is stopped and queue is drained
+			s.mu.Unlock()
+			return
+		}
+		job := s.pop()
+		s.mu.Unlock()
+
+		job.fn()
+		close(job.done)
+	}
+}
+
+// pop returns first job with the highest priority, must be called with s.mu locked
+func (s *parseScheduler) pop() *parseJob {
+	for priority := priorityCount - 1; priority >= 0; priority-- {
+		queue := s.queues[priority]
+		if len(queue) == 0 {
+			continue
+		}
+		job := queue[0]
+		queue[0] = nil
+		s.queues[priority] = queue[1:]
+		s.size--
+		return job
+	}
+	return nil
+}
+
+// Stop rejects new jobs and waits until queued jobs are executed
+func (s *parseScheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
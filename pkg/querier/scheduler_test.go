@@ -0,0 +1,121 @@
+package querier
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockScheduler occupies the only worker of scheduler until returned function is called
+func blockScheduler(t *testing.T, s *parseScheduler) func() {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		err := s.Do(context.TODO(), func() {
+			close(started)
+			<-release
+		})
+		assert.NoError(t, err)
+	}()
+	<-started
+	return func() { close(release) }
+}
+
+func waitQueued(s *parseScheduler, size int) {
+	for {
+		s.mu.Lock()
+		queued := s.size
+		s.mu.Unlock()
+		if queued >= size {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseSchedulerPriority(t *testing.T) {
+	s := newParseScheduler(1, 0)
+	defer s.Stop()
+	release := blockScheduler(t, s)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	submit := func(priority Priority, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Do(WithPriority(context.TODO(), priority), func() {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	submit(PriorityBatch, "batch1")
+	waitQueued(s, 1)
+	submit(PriorityBatch, "batch2")
+	waitQueued(s, 2)
+	submit(PriorityInteractive, "interactive")
+	waitQueued(s, 3)
+
+	release()
+	wg.Wait()
+	assert.Equal(t, []string{"interactive", "batch1", "batch2"}, order)
+}
+
+func TestParseSchedulerCancel(t *testing.T) {
+	s := newParseScheduler(1, 0)
+	defer s.Stop()
+	release := blockScheduler(t, s)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	executed := false
+	err := s.Do(ctx, func() {
+		executed = true
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, executed)
+	s.mu.Lock()
+	assert.Equal(t, 0, s.size)
+	s.mu.Unlock()
+}
+
+func TestParseSchedulerQueueFull(t *testing.T) {
+	s := newParseScheduler(1, 1)
+	defer s.Stop()
+	release := blockScheduler(t, s)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, s.Do(context.TODO(), func() {}))
+	}()
+	waitQueued(s, 1)
+	assert.Equal(t, ErrQueueFull, s.Do(context.TODO(), func() {}))
+	release()
+	<-done
+}
+
+func TestParseSchedulerStop(t *testing.T) {
+	s := newParseScheduler(2, 0)
+	executed := false
+	assert.NoError(t, s.Do(context.TODO(), func() { executed = true }))
+	assert.True(t, executed)
+	s.Stop()
+	assert.Equal(t, ErrSchedulerStopped, s.Do(context.TODO(), func() {}))
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	assert.Equal(t, PriorityInteractive, priorityFromContext(context.TODO()))
+	assert.Equal(t, PriorityBatch, priorityFromContext(WithPriority(context.TODO(), PriorityBatch)))
+	assert.Equal(t, PriorityInteractive, priorityFromContext(WithPriority(context.TODO(), Priority(42))))
+	// priority survives detaching of context in flights
+	assert.Equal(t, PriorityBatch, priorityFromContext(detachContext(WithPriority(context.TODO(), PriorityBatch))))
+}
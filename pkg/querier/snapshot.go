@@ -0,0 +1,44 @@
+package querier
+
+import (
+	"fmt"
+
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// Snapshot copies records selected by filter to dst and builds indexes of dst, so dst can be opened
+// read-only and used as CachedConfig.Snapshot. Records keep their expiration time, expired ones are skipped.
+// Queries are copied even if their lemmas are not selected, such lemmas are fetched by Cached.
+// It returns number of copied records
+func (s *Storage) Snapshot(dst store.CacheStore, filter *RecordFilter) (int, error) {
+	keyTypes, err := filter.keyTypes()
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, t := range keyTypes {
+		t := t
+		err := s.Store.Iterate(marshalKey(filter.Prefix, t), func(entry *store.Entry) error {
+			if !filter.match(s.recordInfo(t, entry)) {
+				return nil
+			}
+			ttl, alive := remainingTTL(entry)
+			if !alive {
+				return nil
+			}
+			if err := dst.Put(entry.Key, entry.Value, ttl); err != nil {
+				return err
+			}
+			copied++
+			return nil
+		})
+		if err != nil {
+			return copied, fmt.Errorf("can not copy records: %w", err)
+		}
+	}
+	snapshot := &Storage{Store: dst, Encoding: s.Encoding, Migrator: s.Migrator}
+	if _, err := snapshot.Reindex(); err != nil {
+		return copied, err
+	}
+	return copied, nil
+}
@@ -0,0 +1,67 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestStorageSnapshot(t *testing.T) {
+	storage := newIndexedStorage(t)
+	assert.NoError(t, storage.PutQuery("print", "print", nil, nil))
+	assert.NoError(t, storage.PutQuery("broken", "", nil, errors.New("test error")))
+	assert.NoError(t, storage.PutLemma("broken", nil, errors.New("test error")))
+
+	dst := store.NewMemory(0)
+	copied, err := storage.Snapshot(dst, &RecordFilter{Types: []string{"query", "lemma"}, WithoutErrors: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, copied)
+
+	snapshot := NewStorage(dst, nil)
+	keys := listKeys(t, snapshot, &RecordFilter{})
+	assert.Equal(t, []string{"query:print", "lemma:get-out", "lemma:print", "lemma:to-begin-with"}, keys)
+	assert.Equal(t, findLemmas(t, storage, &LemmaQuery{Headword: "print"}), findLemmas(t, snapshot, &LemmaQuery{Headword: "print"}))
+	matches, err := snapshot.SearchText("printing", 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, matches)
+
+	_, err = storage.Snapshot(dst, &RecordFilter{Types: []string{"unknown"}})
+	assert.Error(t, err)
+}
+
+func TestCachedSnapshot(t *testing.T) {
+	snapshot := newIndexedStorage(t)
+	assert.NoError(t, snapshot.PutQuery("print", "print", nil, nil))
+	expected := []*parser.Lemma{{Lemma: "hello"}}
+
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "hello").Return("hello", nil, nil).Once()
+	q.On("GetLemma", mock.Anything, "hello").Return(expected, nil).Once()
+	q.On("Close", mock.Anything).Return(nil)
+	user := store.NewMemory(0)
+	cached := NewCached(q, user, &CachedConfig{Snapshot: snapshot.Store})
+
+	// records of snapshot are read without querier
+	result, err := cached.Lookup(context.TODO(), "print")
+	assert.NoError(t, err)
+	assert.Equal(t, "print", result.LemmaID)
+	assert.NotEmpty(t, result.Lemmas)
+
+	// new records are written to user cache only
+	result, err = cached.Lookup(context.TODO(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result.Lemmas)
+	_, err = snapshot.GetLemma("hello")
+	assert.True(t, isMissingRecord(err))
+	_, err = NewStorage(user, nil).GetLemma("hello")
+	assert.NoError(t, err)
+	assert.NoError(t, cached.Close(context.TODO()))
+	q.AssertExpectations(t)
+}
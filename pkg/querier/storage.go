@@ -3,99 +3,341 @@ package querier
 import (
 	"encoding/json"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
-
 	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
 )
 
 const ttlForErros = time.Hour * 24
 
+// Storage keeps queries, lemmas and pages in CacheStore.
+// Missing records are reported with store.ErrNotFound
 type Storage struct {
-	DB *badger.DB
+	// counters are first to be aligned for atomic operations
+	memoryHits   uint64
+	memoryMisses uint64
+	storeHits    uint64
+	storeMisses  uint64
+
+	Store store.CacheStore
+	// Encoding of written queries and lemmas, nil means JSON
+	Encoding *RecordEncoding
+	// Migrator upgrades records with older schema when they are read, nil means default migrations
+	Migrator *Migrator
+	// memory caches decoded queries and lemmas, it's nil if disabled
+	memory *memoryCache
+	// textStatsMu guards textStats
+	textStatsMu sync.Mutex
+	// textStats are totals of full-text index counted by this Storage, nil until they are needed
+	textStats *textStats
+}
+
+// NewStorage returns storage with optional in-process cache of decoded queries and lemmas.
+// Records returned from memory cache are shared, so they must not be modified.
+// Memory cache is invalidated only by changes made through the same Storage.
+func NewStorage(cache store.CacheStore, memory *MemoryCacheConfig) *Storage {
+	s := &Storage{Store: cache}
+	if memory != nil {
+		s.memory = newMemoryCache(memory)
+	}
+	return s
+}
+
+// TierStats counts lookups of records in one tier of cache
+type TierStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CacheStats are statistics of Storage since it was created
+type CacheStats struct {
+	Memory TierStats
+	Store  TierStats
+	// MemoryEntries and MemoryBytes are current size of memory cache
+	MemoryEntries int
+	MemoryBytes   int64
+}
+
+func (s *Storage) Stats() CacheStats {
+	stats := CacheStats{
+		Memory: TierStats{
+			Hits:   atomic.LoadUint64(&s.memoryHits),
+			Misses: atomic.LoadUint64(&s.memoryMisses),
+		},
+		Store: TierStats{
+			Hits:   atomic.LoadUint64(&s.storeHits),
+			Misses: atomic.LoadUint64(&s.storeMisses),
+		},
+	}
+	if s.memory != nil {
+		stats.MemoryEntries, stats.MemoryBytes = s.memory.size()
+	}
+	return stats
 }
 
 func (s *Storage) Close() error {
-	return s.DB.Close()
+	return s.Store.Close()
 }
 
 func (s *Storage) GetQuery(query string) (*CachedQuery, error) {
-	key := marshalKey(query, queryKey)
-	var queryValue CachedQuery
-	if err := s.getFromDB(key, &queryValue); err != nil {
+	value, err := s.getDecoded(marshalKey(query, queryKey),
+		func(data []byte) (interface{}, int, error) {
+			return decodeQuery(data, s.migrator())
+		},
+		func(value interface{}) ([]byte, error) {
+			return encodeQuery(value.(*CachedQuery), s.Encoding)
+		},
+	)
+	if err != nil {
 		return nil, err
 	}
-	return &queryValue, nil
+	return value.(*CachedQuery), nil
 }
 func (s *Storage) PutQuery(query, lemmaID string, suggestions []string, queryErr error) error {
+	return s.PutQueryWithTTL(query, lemmaID, suggestions, queryErr, defaultTTL(queryErr))
+}
+
+// PutQueryWithTTL stores query that expires after ttl. Zero ttl means that query never expires
+func (s *Storage) PutQueryWithTTL(query, lemmaID string, suggestions []string, queryErr error, ttl time.Duration) error {
 	key := marshalKey(query, queryKey)
 	var errString string
 	if queryErr != nil {
 		errString = queryErr.Error()
 	}
 	value := CachedQuery{
-		LemmaID:     lemmaID,
-		Suggestions: suggestions,
-		Error:       errString,
-		CreatedAt:   time.Now(),
+		LemmaID:       lemmaID,
+		Suggestions:   suggestions,
+		Error:         errString,
+		CreatedAt:     time.Now(),
+		SchemaVersion: s.migrator().version,
 	}
-	data, err := json.Marshal(value)
+	data, err := encodeQuery(&value, s.Encoding)
 	if err != nil {
 		return err
 	}
-	return s.DB.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry(key, data)
-		if queryErr != nil {
-			entry = entry.WithTTL(ttlForErros)
-		}
-		return txn.SetEntry(entry)
-	})
+	if queryErr != nil {
+		lemmaID = ""
+	}
+	batch := &writeBatch{}
+	batch.put(key, data, ttl)
+	s.linkQuery(batch, query, s.queryLemmaID(query), lemmaID, ttl)
+	return s.apply(batch)
 }
 
 func (s *Storage) GetLemma(lemmaID string) (*CachedLemma, error) {
-	key := marshalKey(lemmaID, lemmaKey)
-	var lemmaValue CachedLemma
-	if err := s.getFromDB(key, &lemmaValue); err != nil {
+	value, err := s.getDecoded(marshalKey(lemmaID, lemmaKey),
+		func(data []byte) (interface{}, int, error) {
+			return decodeLemma(data, s.migrator())
+		},
+		func(value interface{}) ([]byte, error) {
+			return encodeLemma(value.(*CachedLemma), s.Encoding)
+		},
+	)
+	if err != nil {
 		return nil, err
 	}
-	return &lemmaValue, nil
+	return value.(*CachedLemma), nil
 }
 
-func (s *Storage) getFromDB(key []byte, vPtr interface{}) error {
-	return s.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		if err != nil {
-			return err
+// hasNewerRecord reports if record stored under key has newer schema than migrator of s.
+// Such record is written by newer version sharing the cache and must not be downgraded
+func (s *Storage) hasNewerRecord(key []byte) bool {
+	entry, err := s.Store.Get(key)
+	if err != nil {
+		return false
+	}
+	if keyType(key[0]) == queryKey {
+		_, _, err = decodeQuery(entry.Value, s.migrator())
+	} else {
+		_, _, err = decodeLemma(entry.Value, s.migrator())
+	}
+	return errors.Is(err, ErrNewerSchema)
+}
+
+// getDecoded returns record from memory cache or decodes it from store and caches it in memory.
+// decode returns schema version of stored record, if it's older, upgraded record is written back with encode.
+func (s *Storage) getDecoded(
+	key []byte,
+	decode func(data []byte) (interface{}, int, error),
+	encode func(value interface{}) ([]byte, error),
+) (interface{}, error) {
+	var generation uint64
+	if s.memory != nil {
+		if value, ok := s.memory.get(key); ok {
+			atomic.AddUint64(&s.memoryHits, 1)
+			return value, nil
+		}
+		atomic.AddUint64(&s.memoryMisses, 1)
+		generation = s.memory.currentGeneration()
+	}
+	entry, err := s.Store.Get(key)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			atomic.AddUint64(&s.storeMisses, 1)
 		}
-		return item.Value(func(value []byte) error {
-			return json.Unmarshal(value, vPtr)
-		})
-	})
+		return nil, err
+	}
+	atomic.AddUint64(&s.storeHits, 1)
+	value, version, err := decode(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if version != s.migrator().version {
+		writeErr := s.writeBack(entry, func() ([]byte, error) {
+			return encode(value)
+		}, nil)
+		if writeErr != nil { // nolint:staticcheck // todo
+			// TODO: log this event, record is upgraded again on next read
+		}
+		return value, nil
+	}
+	if s.memory != nil {
+		s.memory.add(key, value, int64(len(key)+len(entry.Value)), entry.ExpiresAt, generation)
+	}
+	return value, nil
+}
+
+// DeleteQuery removes query from storage
+func (s *Storage) DeleteQuery(query string) error {
+	return s.deleteEntry(marshalKey(query, queryKey))
+}
+
+// DeleteLemma removes lemmas and archived page of lemmaID from storage with queries that point at it
+func (s *Storage) DeleteLemma(lemmaID string) error {
+	if err := s.deleteEntry(marshalKey(lemmaID, lemmaKey)); err != nil {
+		return err
+	}
+	return s.deleteEntry(marshalKey(lemmaID, pageKey))
+}
+
+// PurgeMemory removes all records from memory cache, so they are read from store again.
+// It's needed after store was changed not through this Storage.
+func (s *Storage) PurgeMemory() {
+	if s.memory != nil {
+		s.memory.purge()
+	}
+}
+
+func (s *Storage) getValue(key []byte, vPtr interface{}) error {
+	entry, err := s.Store.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(entry.Value, vPtr)
 }
 
 func (s *Storage) PutLemma(lemmaID string, lemmas []*parser.Lemma, lemmaErr error) error {
+	return s.PutLemmaWithTTL(lemmaID, lemmas, lemmaErr, defaultTTL(lemmaErr))
+}
+
+// PutLemmaWithTTL stores lemmas that expire after ttl. Zero ttl means that lemmas never expire
+func (s *Storage) PutLemmaWithTTL(lemmaID string, lemmas []*parser.Lemma, lemmaErr error, ttl time.Duration) error {
+	return s.putLemma(lemmaID, lemmas, lemmaErr, time.Now(), ttl)
+}
+
+// putLemma stores lemmas that were fetched at createdAt
+func (s *Storage) putLemma(lemmaID string, lemmas []*parser.Lemma, lemmaErr error, createdAt time.Time, ttl time.Duration) error {
 	var errString string
 	if lemmaErr != nil {
 		errString = lemmaErr.Error()
 	}
 	key := marshalKey(lemmaID, lemmaKey)
 	value := CachedLemma{
-		Lemmas:    lemmas,
-		Error:     errString,
-		CreatedAt: time.Now(),
+		Lemmas:        lemmas,
+		Error:         errString,
+		CreatedAt:     createdAt,
+		SchemaVersion: s.migrator().version,
+	}
+	data, err := encodeLemma(&value, s.Encoding)
+	if err != nil {
+		return err
+	}
+	if lemmaErr != nil {
+		lemmas = nil
+	}
+	batch := &writeBatch{}
+	batch.put(key, data, ttl)
+	if err := s.indexLemma(batch, lemmaID, lemmas, ttl); err != nil {
+		return err
+	}
+	return s.apply(batch)
+}
+
+func (s *Storage) putEntry(key, data []byte, ttl time.Duration) error {
+	batch := &writeBatch{}
+	batch.put(key, data, ttl)
+	return s.apply(batch)
+}
+
+// writeBatch collects writes of record and its index entries, so they are applied to store together
+type writeBatch struct {
+	writes []store.Write
+	// text is change of totals of full-text index made by writes
+	text textStats
+}
+
+func (b *writeBatch) put(key, value []byte, ttl time.Duration) {
+	b.writes = append(b.writes, store.Write{Key: key, Value: value, TTL: ttl})
+}
+
+func (b *writeBatch) delete(key []byte) {
+	b.writes = append(b.writes, store.Write{Key: key, Delete: true})
+}
+
+// apply applies batch in one transaction if store supports it and invalidates written records in memory cache
+func (s *Storage) apply(batch *writeBatch) error {
+	err := store.Apply(s.Store, batch.writes)
+	for _, write := range batch.writes {
+		s.invalidate(write.Key)
 	}
-	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	return s.DB.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry(key, data)
-		if lemmaErr != nil {
-			entry = entry.WithTTL(ttlForErros)
+	s.addTextStats(batch.text)
+	return nil
+}
+
+// deleteEntry removes entry with its index entries. Removing of lemma removes queries that point at it
+func (s *Storage) deleteEntry(key []byte) error {
+	t, id := keyType(key[0]), string(key[1:])
+	var lemmaID string
+	if t == queryKey {
+		lemmaID = s.queryLemmaID(id)
+	}
+	batch := &writeBatch{}
+	batch.delete(key)
+	switch t {
+	case queryKey:
+		s.linkQuery(batch, id, lemmaID, "", 0)
+	case lemmaKey:
+		if err := s.unindexLemma(batch, id); err != nil {
+			return err
 		}
-		return txn.SetEntry(entry)
-	})
+	}
+	if err := s.apply(batch); err != nil {
+		return err
+	}
+	if t == lemmaKey {
+		return s.invalidateAliases(id)
+	}
+	return nil
+}
+
+func (s *Storage) invalidate(key []byte) {
+	if s.memory != nil {
+		s.memory.invalidate(key)
+	}
+}
+
+// defaultTTL returns ttl for record with err: errors expire, successful records don't
+func defaultTTL(err error) time.Duration {
+	if err != nil {
+		return ttlForErros
+	}
+	return 0
 }
 
 type keyType byte
@@ -103,6 +345,17 @@ type keyType byte
 const (
 	queryKey keyType = iota + 1
 	lemmaKey
+	pageKey
+	// indexKey entries are postings of secondary indexes of lemmas
+	indexKey
+	// indexedKey entries list terms under which lemmaID is indexed
+	indexedKey
+	// textKey entries are postings of full-text index of lemmas
+	textKey
+	// textDocKey entries list terms and lengths of lemmas of lemmaID in full-text index
+	textDocKey
+	// aliasKey entries link lemmaID with queries that point at it
+	aliasKey
 )
 
 type CachedQuery struct {
@@ -110,20 +363,39 @@ type CachedQuery struct {
 	Suggestions []string
 	Error       string
 	CreatedAt   time.Time
+	// SchemaVersion is zero for records written before schema versions
+	SchemaVersion int
 }
 
 func (cq *CachedQuery) Return() (lemmaID string, suggestions []string, err error) {
-	return cq.LemmaID, cq.Suggestions, errors.New(cq.Error)
+	return cq.LemmaID, cq.Suggestions, restoreError(cq.Error)
 }
 
 type CachedLemma struct {
 	Lemmas    []*parser.Lemma
 	Error     string
 	CreatedAt time.Time
+	// SchemaVersion is zero for records written before schema versions
+	SchemaVersion int
 }
 
 func (cl *CachedLemma) Return() ([]*parser.Lemma, error) {
-	return cl.Lemmas, errors.New(cl.Error)
+	return cl.Lemmas, restoreError(cl.Error)
+}
+
+// restoreError returns error from its stored message.
+// Empty message means no error, known errors are restored, so they can be checked with errors.Is
+func restoreError(msg string) error {
+	switch msg {
+	case "":
+		return nil
+	case ErrSuggestions.Error():
+		return ErrSuggestions
+	case ErrEmptyLemmaID.Error():
+		return ErrEmptyLemmaID
+	default:
+		return errors.New(msg)
+	}
 }
 
 func marshalKey(k string, t keyType) []byte {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
 	"github.com/dgraph-io/badger/v2"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,7 +25,7 @@ func getStorage(t *testing.T) *Storage {
 		return nil
 	}
 	return &Storage{
-		DB: db,
+		Store: store.NewBadger(db),
 	}
 }
 
@@ -81,9 +82,10 @@ func TestQuery(t *testing.T) {
 				queryErr = errors.New(tc.errorMsg)
 			}
 			expectedQuery := &CachedQuery{
-				LemmaID:     tc.lemmaID,
-				Suggestions: tc.suggestions,
-				Error:       tc.errorMsg,
+				LemmaID:       tc.lemmaID,
+				Suggestions:   tc.suggestions,
+				Error:         tc.errorMsg,
+				SchemaVersion: CurrentSchemaVersion,
 			}
 			err := storage.PutQuery(name,
 				expectedQuery.LemmaID,
@@ -131,8 +133,9 @@ func TestLemma(t *testing.T) {
 				lemmaErr = errors.New(tc.errorMsg)
 			}
 			expectedLemma := &CachedLemma{
-				Lemmas: tc.lemmas,
-				Error:  tc.errorMsg,
+				Lemmas:        tc.lemmas,
+				Error:         tc.errorMsg,
+				SchemaVersion: CurrentSchemaVersion,
 			}
 			err := storage.PutLemma(name,
 				expectedLemma.Lemmas,
@@ -195,3 +198,10 @@ func TestCachedKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestRestoreError(t *testing.T) {
+	assert.NoError(t, restoreError(""))
+	assert.Equal(t, ErrSuggestions, restoreError(ErrSuggestions.Error()))
+	assert.Equal(t, ErrEmptyLemmaID, restoreError(ErrEmptyLemmaID.Error()))
+	assert.EqualError(t, restoreError("other"), "other")
+}
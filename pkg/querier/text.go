@@ -0,0 +1,359 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kljensen/snowball/english"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// parameters of BM25 ranking
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize splits text to lower case words and stems them
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		if len([]rune(word)) < 2 { // nolint:gomnd // single letters are not searchable
+			continue
+		}
+		tokens = append(tokens, english.Stem(word, true))
+	}
+	return tokens
+}
+
+// lemmaText returns searchable text of lemma
+func lemmaText(lemma *parser.Lemma) string {
+	parts := make([]string, 0, len(lemma.Examples)+2) // nolint:gomnd // guide word and definition
+	parts = append(parts, lemma.GuideWord, lemma.Definition)
+	parts = append(parts, lemma.Examples...)
+	return strings.Join(parts, " ")
+}
+
+// textDocument lists terms of lemmas of lemmaID and number of terms of every lemma.
+// Every lemma of record is separate document of full-text index
+type textDocument struct {
+	Terms   []string `json:"terms"`
+	Lengths []int    `json:"lengths"`
+}
+
+// length returns number of terms of every lemma of document
+func (d *textDocument) length() int {
+	total := 0
+	for _, length := range d.Lengths {
+		total += length
+	}
+	return total
+}
+
+// textStats are totals of full-text index that BM25 needs. Storage counts them from the index on the first search
+// and then updates them by its own writes, so they are approximate: writes of other processes sharing the store
+// and expired entries are not seen until Storage.Reindex or restart
+type textStats struct {
+	Documents int
+	Length    int
+}
+
+// averageLength returns average number of terms of indexed lemma
+func (t *textStats) averageLength() float64 {
+	if t.Documents == 0 || t.Length == 0 {
+		return 1
+	}
+	return float64(t.Length) / float64(t.Documents)
+}
+
+// getTextStats returns totals of full-text index, they are counted from the index if it's needed
+func (s *Storage) getTextStats() (*textStats, error) {
+	s.textStatsMu.Lock()
+	defer s.textStatsMu.Unlock()
+	if s.textStats == nil {
+		stats, err := s.countTextStats()
+		if err != nil {
+			return nil, err
+		}
+		s.textStats = stats
+	}
+	stats := *s.textStats
+	return &stats, nil
+}
+
+// countTextStats counts totals of every document of full-text index
+func (s *Storage) countTextStats() (*textStats, error) {
+	var stats textStats
+	err := s.Store.Iterate([]byte{byte(textDocKey)}, func(entry *store.Entry) error {
+		var doc textDocument
+		if err := json.Unmarshal(entry.Value, &doc); err != nil {
+			return nil
+		}
+		stats.Documents += len(doc.Lengths)
+		stats.Length += doc.length()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not iterate text index: %w", err)
+	}
+	return &stats, nil
+}
+
+// addTextStats adds change of full-text index made by this Storage to counted totals
+func (s *Storage) addTextStats(change textStats) {
+	s.textStatsMu.Lock()
+	defer s.textStatsMu.Unlock()
+	if s.textStats == nil {
+		// they are counted with the change on the first search
+		return
+	}
+	s.textStats.Documents += change.Documents
+	s.textStats.Length += change.Length
+	if s.textStats.Documents < 0 || s.textStats.Length < 0 {
+		// entries were removed by another process or expired, so totals are counted again
+		s.textStats = nil
+	}
+}
+
+// resetTextStats drops counted totals, so they are counted again on the next search
+func (s *Storage) resetTextStats() {
+	s.textStatsMu.Lock()
+	defer s.textStatsMu.Unlock()
+	s.textStats = nil
+}
+
+// textPostingPrefix is prefix of postings of term, posting value is term frequency of every lemma
+func textPostingPrefix(term string) []byte {
+	key := make([]byte, 0, len(term)+2) // nolint:gomnd // type and separator
+	key = append(key, byte(textKey))
+	key = append(key, term...)
+	return append(key, 0)
+}
+
+func textPostingKey(term, lemmaID string) []byte {
+	return append(textPostingPrefix(term), lemmaID...)
+}
+
+// indexText adds to batch writes that replace full-text index entries of lemmaID
+func (s *Storage) indexText(batch *writeBatch, lemmaID string, lemmas []*parser.Lemma, ttl time.Duration) error {
+	if err := s.unindexText(batch, lemmaID); err != nil {
+		return err
+	}
+	if len(lemmas) == 0 {
+		return nil
+	}
+	doc := &textDocument{Lengths: make([]int, len(lemmas))}
+	frequencies := make(map[string]map[int]int)
+	for i, lemma := range lemmas {
+		tokens := tokenize(lemmaText(lemma))
+		doc.Lengths[i] = len(tokens)
+		for _, token := range tokens {
+			if frequencies[token] == nil {
+				frequencies[token] = make(map[int]int)
+				doc.Terms = append(doc.Terms, token)
+			}
+			frequencies[token][i]++
+		}
+	}
+	for _, term := range doc.Terms {
+		data, err := json.Marshal(frequencies[term])
+		if err != nil {
+			return err
+		}
+		batch.put(textPostingKey(term, lemmaID), data, ttl)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	batch.put(marshalKey(lemmaID, textDocKey), data, ttl)
+	batch.text.Documents += len(doc.Lengths)
+	batch.text.Length += doc.length()
+	return nil
+}
+
+// unindexText adds to batch removal of full-text index entries of lemmaID
+func (s *Storage) unindexText(batch *writeBatch, lemmaID string) error {
+	docKey := marshalKey(lemmaID, textDocKey)
+	entry, err := s.Store.Get(docKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can not get text index entry: %w", err)
+	}
+	var doc textDocument
+	if err := json.Unmarshal(entry.Value, &doc); err != nil {
+		// postings of broken entry are unknown, they are ignored without it anyway
+		doc = textDocument{}
+	}
+	for _, term := range doc.Terms {
+		batch.delete(textPostingKey(term, lemmaID))
+	}
+	batch.delete(docKey)
+	batch.text.Documents -= len(doc.Lengths)
+	batch.text.Length -= doc.length()
+	return nil
+}
+
+// TextMatch is lemma found by SearchText
+type TextMatch struct {
+	LemmaID string        `json:"lemma_id"`
+	Lemma   *parser.Lemma `json:"lemma"`
+	Score   float64       `json:"score"`
+}
+
+// textDocumentID is lemma of record in full-text index
+type textDocumentID struct {
+	lemmaID string
+	index   int
+}
+
+// SearchText returns cached lemmas whose definitions, examples or guide words match text,
+// ranked by BM25 with the best match first. limit is maximum number of matches, zero means no limit
+func (s *Storage) SearchText(text string, limit int) ([]*TextMatch, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range tokenize(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	stats, err := s.getTextStats()
+	if err != nil {
+		return nil, err
+	}
+	averageLength := stats.averageLength()
+	// documents are loaded only for lemmas that contain terms
+	documents := make(map[string]*textDocument)
+	scores := make(map[textDocumentID]float64)
+	for _, term := range terms {
+		frequencies, err := s.termFrequencies(term, documents)
+		if err != nil {
+			return nil, err
+		}
+		found := float64(len(frequencies))
+		total := math.Max(float64(stats.Documents), found)
+		idf := math.Log((total-found+0.5)/(found+0.5) + 1) // nolint:gomnd // BM25
+		for id, tf := range frequencies {
+			length := documents[id.lemmaID].Lengths[id.index]
+			norm := 1 - bm25B + bm25B*float64(length)/averageLength
+			scores[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+	}
+	ids := make([]textDocumentID, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		if ids[i].lemmaID != ids[j].lemmaID {
+			return ids[i].lemmaID < ids[j].lemmaID
+		}
+		return ids[i].index < ids[j].index
+	})
+	return s.textMatches(ids, scores, limit)
+}
+
+// termFrequencies returns frequency of term in every indexed lemma that contains it.
+// documents of lemmas are loaded to documents, postings of lemmas without document are ignored
+func (s *Storage) termFrequencies(term string, documents map[string]*textDocument) (map[textDocumentID]int, error) {
+	postings := make(map[string]map[int]int)
+	prefix := textPostingPrefix(term)
+	err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+		var frequencies map[int]int
+		if err := json.Unmarshal(entry.Value, &frequencies); err != nil {
+			return nil
+		}
+		postings[string(entry.Key[len(prefix):])] = frequencies
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not iterate text index: %w", err)
+	}
+	result := make(map[textDocumentID]int)
+	for lemmaID, frequencies := range postings {
+		doc, ok := documents[lemmaID]
+		if !ok {
+			doc, err = s.getTextDocument(lemmaID)
+			if err != nil {
+				return nil, err
+			}
+			documents[lemmaID] = doc
+		}
+		if doc == nil {
+			continue
+		}
+		for index, tf := range frequencies {
+			if index >= 0 && index < len(doc.Lengths) {
+				result[textDocumentID{lemmaID: lemmaID, index: index}] = tf
+			}
+		}
+	}
+	return result, nil
+}
+
+// getTextDocument returns document of lemmaID or nil if lemmaID is not indexed
+func (s *Storage) getTextDocument(lemmaID string) (*textDocument, error) {
+	entry, err := s.Store.Get(marshalKey(lemmaID, textDocKey))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not get text index entry: %w", err)
+	}
+	var doc textDocument
+	if err := json.Unmarshal(entry.Value, &doc); err != nil {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+// textMatches loads lemmas of ranked ids, lemmas that are not cached anymore are skipped
+func (s *Storage) textMatches(ids []textDocumentID, scores map[textDocumentID]float64, limit int) ([]*TextMatch, error) {
+	var matches []*TextMatch
+	records := make(map[string][]*parser.Lemma)
+	for _, id := range ids {
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+		lemmas, ok := records[id.lemmaID]
+		if !ok {
+			cached, err := s.GetLemma(id.lemmaID)
+			switch {
+			case isMissingRecord(err):
+			case err != nil:
+				return nil, fmt.Errorf("can not get lemma '%s': %w", id.lemmaID, err)
+			default:
+				lemmas = cached.Lemmas
+			}
+			records[id.lemmaID] = lemmas
+		}
+		if id.index >= len(lemmas) {
+			continue
+		}
+		matches = append(matches, &TextMatch{
+			LemmaID: id.lemmaID,
+			Lemma:   lemmas[id.index],
+			Score:   scores[id],
+		})
+	}
+	return matches, nil
+}
@@ -0,0 +1,107 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"print", "book", "in", "larg", "quantiti"},
+		tokenize("Printed books, in LARGE quantities!"))
+	assert.Empty(t, tokenize(" a - ? "))
+}
+
+func TestSearchText(t *testing.T) {
+	storage := newIndexedStorage(t)
+
+	matches, err := storage.SearchText("leaving a closed vehicle", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, matches, 2) {
+		assert.Equal(t, "get-out", matches[0].LemmaID)
+		assert.Equal(t, "to leave a closed vehicle, building, etc.", matches[0].Lemma.Definition)
+		assert.True(t, matches[0].Score > matches[1].Score)
+	}
+	matches, err = storage.SearchText("leaving a closed vehicle", 1)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+
+	matches, err = storage.SearchText("photograph of a painting", 0)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, matches) {
+		assert.Equal(t, "print", matches[0].LemmaID)
+		assert.Contains(t, matches[0].Lemma.Definition, "photograph")
+	}
+
+	matches, err = storage.SearchText("zzqx", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestTextIndexMaintenance(t *testing.T) {
+	storage := NewStorage(store.NewMemory(0), nil)
+	lemmas := []*parser.Lemma{
+		{Lemma: "print", Definition: "to produce writing on paper"},
+		{Lemma: "print", Definition: "a mark left on a surface", Examples: []string{"Paw prints in the snow."}},
+	}
+	assert.NoError(t, storage.PutLemma("print", lemmas, nil))
+	assertTextStats(t, storage, lemmas)
+	matches, err := storage.SearchText("snow", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, matches, 1) {
+		assert.Equal(t, lemmas[1], matches[0].Lemma)
+	}
+
+	assert.NoError(t, storage.PutLemma("print", lemmas[:1], nil))
+	assertTextStats(t, storage, lemmas[:1])
+	matches, err = storage.SearchText("snow", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	assert.NoError(t, storage.DeleteLemma("print"))
+	assertTextStats(t, storage, nil)
+	matches, err = storage.SearchText("writing", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.NoError(t, storage.Store.Iterate([]byte{byte(textKey)}, func(entry *store.Entry) error {
+		t.Errorf("unexpected text index entry %q", entry.Key)
+		return nil
+	}))
+}
+
+func TestTextStatsCounted(t *testing.T) {
+	cache := store.NewMemory(0)
+	writer := NewStorage(cache, nil)
+	lemmas := []*parser.Lemma{
+		{Lemma: "print", Definition: "to produce writing on paper"},
+		{Lemma: "write", Definition: "to make letters on paper"},
+	}
+	assert.NoError(t, writer.PutLemma("print", lemmas[:1], nil))
+	reader := NewStorage(cache, nil)
+	assertTextStats(t, reader, lemmas[:1])
+
+	// totals are kept by every Storage, so writes of another one are seen after Reindex
+	assert.NoError(t, writer.PutLemma("write", lemmas[1:], nil))
+	assertTextStats(t, writer, lemmas)
+	assertTextStats(t, reader, lemmas[:1])
+	_, err := reader.Reindex()
+	assert.NoError(t, err)
+	assertTextStats(t, reader, lemmas)
+}
+
+// assertTextStats checks that totals of full-text index are counted from lemmas
+func assertTextStats(t *testing.T, storage *Storage, lemmas []*parser.Lemma) {
+	t.Helper()
+	var expected textStats
+	for _, lemma := range lemmas {
+		expected.Documents++
+		expected.Length += len(tokenize(lemmaText(lemma)))
+	}
+	stats, err := storage.getTextStats()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &expected, stats)
+	}
+}
@@ -0,0 +1,415 @@
+package querier
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// ExportFormat is format of exported records
+type ExportFormat int
+
+const (
+	// ExportJSONL writes one record per line
+	ExportJSONL ExportFormat = iota
+	// ExportTarGz writes gzip compressed tar with manifest and chunks of JSONL records
+	ExportTarGz
+)
+
+// ParseExportFormat parses "jsonl" or "tar.gz"
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch s {
+	case "jsonl":
+		return ExportJSONL, nil
+	case "tar.gz":
+		return ExportTarGz, nil
+	default:
+		return 0, fmt.Errorf("unknown export format '%s'", s)
+	}
+}
+
+// MergeStrategy specifies what happens when imported record is already stored
+type MergeStrategy int
+
+const (
+	// MergeKeepNewer replaces stored record only if imported one was created later
+	MergeKeepNewer MergeStrategy = iota
+	// MergeOverwrite always replaces stored record
+	MergeOverwrite
+	// MergeSkipExisting never replaces stored record
+	MergeSkipExisting
+)
+
+// ParseMergeStrategy parses "newer", "overwrite" or "skip"
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	switch s {
+	case "newer":
+		return MergeKeepNewer, nil
+	case "overwrite":
+		return MergeOverwrite, nil
+	case "skip":
+		return MergeSkipExisting, nil
+	default:
+		return 0, fmt.Errorf("unknown merge strategy '%s'", s)
+	}
+}
+
+const (
+	recordTypeQuery = "query"
+	recordTypeLemma = "lemma"
+
+	tarManifest = "manifest.json"
+	// tarChunkRecords is number of records in one file of tarball
+	tarChunkRecords = 1000
+	tarFileMode     = 0644
+	// maxRecordLine limits size of one imported record
+	maxRecordLine = 64 << 20
+)
+
+// ExportRecord is one exported query or lemma
+type ExportRecord struct {
+	// Type is "query" or "lemma"
+	Type      string    `json:"type"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	// TTL is remaining time to live in seconds, zero means that record never expires
+	TTL int64 `json:"ttl,omitempty"`
+	// Value is JSON encoded CachedQuery or CachedLemma
+	Value json.RawMessage `json:"value"`
+}
+
+// exportManifest is the first file of tarball
+type exportManifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+}
+
+// TransferResult describes what happened with record during export or import
+type TransferResult struct {
+	Type string
+	Key  string
+	// Skipped is true if record was not imported because of merge strategy
+	Skipped bool
+	// Err is error of decoding or storing record
+	Err error
+}
+
+// Export writes every query and lemma upgraded to current schema to w.
+// Records that can't be decoded are reported and skipped, report is called for every record.
+func (s *Storage) Export(w io.Writer, format ExportFormat, report func(*TransferResult)) error {
+	switch format {
+	case ExportJSONL:
+		encoder := json.NewEncoder(w)
+		return s.exportRecords(report, func(record *ExportRecord) error {
+			return encoder.Encode(record)
+		})
+	case ExportTarGz:
+		return s.exportTarGz(w, report)
+	default:
+		return fmt.Errorf("unknown export format %d", format)
+	}
+}
+
+func (s *Storage) exportTarGz(w io.Writer, report func(*TransferResult)) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	now := time.Now()
+	manifest, err := json.Marshal(&exportManifest{
+		SchemaVersion: s.migrator().version,
+		ExportedAt:    now,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tarWriter, tarManifest, manifest, now); err != nil {
+		return err
+	}
+	// records are written in chunks, because size of file should be known before its content
+	var chunk bytes.Buffer
+	encoder := json.NewEncoder(&chunk)
+	chunks, inChunk := 0, 0
+	flush := func() error {
+		if inChunk == 0 {
+			return nil
+		}
+		name := fmt.Sprintf("records-%06d.jsonl", chunks)
+		if err := writeTarFile(tarWriter, name, chunk.Bytes(), now); err != nil {
+			return err
+		}
+		chunk.Reset()
+		chunks++
+		inChunk = 0
+		return nil
+	}
+	err = s.exportRecords(report, func(record *ExportRecord) error {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		inChunk++
+		if inChunk == tarChunkRecords {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func writeTarFile(w *tar.Writer, name string, content []byte, modTime time.Time) error {
+	err := w.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    tarFileMode,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// exportRecords calls write for every query and lemma, errors of write stop export
+func (s *Storage) exportRecords(report func(*TransferResult), write func(*ExportRecord) error) error {
+	for _, t := range []keyType{queryKey, lemmaKey} {
+		t := t
+		prefix := []byte{byte(t)}
+		err := s.Store.Iterate(prefix, func(entry *store.Entry) error {
+			record, err := s.exportRecord(t, entry)
+			report(&TransferResult{
+				Type: recordTypeName(t),
+				Key:  string(entry.Key[len(prefix):]),
+				Err:  err,
+			})
+			if err != nil {
+				// undecodable record is skipped
+				return nil
+			}
+			return write(record)
+		})
+		if err != nil {
+			return fmt.Errorf("export failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) exportRecord(t keyType, entry *store.Entry) (*ExportRecord, error) {
+	record := &ExportRecord{
+		Type: recordTypeName(t),
+		Key:  string(entry.Key[1:]),
+	}
+	if !entry.ExpiresAt.IsZero() {
+		// round up, so record doesn't get infinite ttl
+		record.TTL = int64((time.Until(entry.ExpiresAt) + time.Second - 1) / time.Second)
+		if record.TTL <= 0 {
+			record.TTL = 1
+		}
+	}
+	var value interface{}
+	if t == queryKey {
+		query, _, err := decodeQuery(entry.Value, s.migrator())
+		if err != nil {
+			return nil, err
+		}
+		record.CreatedAt, value = query.CreatedAt, query
+	} else {
+		lemma, _, err := decodeLemma(entry.Value, s.migrator())
+		if err != nil {
+			return nil, err
+		}
+		record.CreatedAt, value = lemma.CreatedAt, lemma
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	record.Value = data
+	return record, nil
+}
+
+// Import reads records exported in any format and stores them according to strategy.
+// Records with older schema are upgraded. report is called for every record.
+func (s *Storage) Import(r io.Reader, strategy MergeStrategy, report func(*TransferResult)) error {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return s.importTarGz(buffered, strategy, report)
+	}
+	return s.importJSONL(buffered, strategy, report)
+}
+
+func (s *Storage) importTarGz(r io.Reader, strategy MergeStrategy, report func(*TransferResult)) error {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("can not decompress export: %w", err)
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("can not read export: %w", err)
+		}
+		if header.Name == tarManifest || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := s.importJSONL(tarReader, strategy, report); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Storage) importJSONL(r io.Reader, strategy MergeStrategy, report func(*TransferResult)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record ExportRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			report(&TransferResult{Err: fmt.Errorf("can not decode record: %w", err)})
+			continue
+		}
+		skipped, err := s.importRecord(&record, strategy)
+		report(&TransferResult{
+			Type:    record.Type,
+			Key:     record.Key,
+			Skipped: skipped,
+			Err:     err,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("can not read export: %w", err)
+	}
+	return nil
+}
+
+// importRecord stores record and reports if it was skipped because of strategy
+func (s *Storage) importRecord(record *ExportRecord, strategy MergeStrategy) (bool, error) {
+	var key, data []byte
+	var createdAt time.Time
+	var lemmas []*parser.Lemma
+	var lemmaID string
+	switch record.Type {
+	case recordTypeQuery:
+		query, _, err := decodeQuery(record.Value, s.migrator())
+		if err != nil {
+			return false, err
+		}
+		key, createdAt = marshalKey(record.Key, queryKey), query.CreatedAt
+		if data, err = encodeQuery(query, s.Encoding); err != nil {
+			return false, err
+		}
+		if query.Error == "" {
+			lemmaID = query.LemmaID
+		}
+	case recordTypeLemma:
+		lemma, _, err := decodeLemma(record.Value, s.migrator())
+		if err != nil {
+			return false, err
+		}
+		key, createdAt = marshalKey(record.Key, lemmaKey), lemma.CreatedAt
+		if data, err = encodeLemma(lemma, s.Encoding); err != nil {
+			return false, err
+		}
+		if lemma.Error == "" {
+			lemmas = lemma.Lemmas
+		}
+	default:
+		return false, fmt.Errorf("unknown record type '%s'", record.Type)
+	}
+	keep, err := s.keepExisting(key, createdAt, strategy)
+	if err != nil || keep {
+		return keep, err
+	}
+	ttl := time.Duration(record.TTL) * time.Second
+	var previous string
+	if record.Type == recordTypeQuery {
+		previous = s.queryLemmaID(record.Key)
+	}
+	batch := &writeBatch{}
+	batch.put(key, data, ttl)
+	if record.Type == recordTypeLemma {
+		if err := s.indexLemma(batch, record.Key, lemmas, ttl); err != nil {
+			return false, err
+		}
+	} else {
+		s.linkQuery(batch, record.Key, previous, lemmaID, ttl)
+	}
+	return false, s.apply(batch)
+}
+
+// keepExisting reports if stored record of key should not be replaced by record created at createdAt.
+// Records with newer schema are always kept, so they are not downgraded, broken records are always replaced
+func (s *Storage) keepExisting(key []byte, createdAt time.Time, strategy MergeStrategy) (bool, error) {
+	entry, err := s.Store.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var existingCreatedAt time.Time
+	if keyType(key[0]) == queryKey {
+		var query *CachedQuery
+		query, _, err = decodeQuery(entry.Value, s.migrator())
+		if err == nil {
+			existingCreatedAt = query.CreatedAt
+		}
+	} else {
+		var lemma *CachedLemma
+		lemma, _, err = decodeLemma(entry.Value, s.migrator())
+		if err == nil {
+			existingCreatedAt = lemma.CreatedAt
+		}
+	}
+	switch {
+	case errors.Is(err, ErrNewerSchema):
+		return true, nil
+	case err != nil:
+		return false, nil
+	case strategy == MergeSkipExisting:
+		return true, nil
+	case strategy == MergeOverwrite:
+		return false, nil
+	default:
+		return !createdAt.After(existingCreatedAt), nil
+	}
+}
+
+func recordTypeName(t keyType) string {
+	switch t {
+	case queryKey:
+		return recordTypeQuery
+	case pageKey:
+		return recordTypePage
+	default:
+		return recordTypeLemma
+	}
+}
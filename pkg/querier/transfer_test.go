@@ -0,0 +1,130 @@
+package querier
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// transferCounts counts results of export or import
+type transferCounts struct {
+	done    int
+	skipped int
+	failed  int
+}
+
+func (c *transferCounts) report(result *TransferResult) {
+	switch {
+	case result.Err != nil:
+		c.failed++
+	case result.Skipped:
+		c.skipped++
+	default:
+		c.done++
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportJSONL, ExportTarGz} {
+		source := &Storage{Store: store.NewMemory(0), Encoding: &RecordEncoding{Binary: true}}
+		assert.NoError(t, source.PutLemma("hello", []*parser.Lemma{{Lemma: "hello"}}, nil))
+		assert.NoError(t, source.PutQuery("hello", "hello", nil, nil))
+		assert.NoError(t, source.PutQueryWithTTL("helo", "", []string{"hello"}, ErrSuggestions, time.Hour))
+		assert.NoError(t, source.Store.Put(marshalKey("broken", lemmaKey), []byte("broken"), 0))
+
+		var exported bytes.Buffer
+		var exportCounts transferCounts
+		assert.NoError(t, source.Export(&exported, format, exportCounts.report))
+		assert.Equal(t, transferCounts{done: 3, failed: 1}, exportCounts)
+
+		target := &Storage{Store: store.NewMemory(0)}
+		var importCounts transferCounts
+		assert.NoError(t, target.Import(&exported, MergeKeepNewer, importCounts.report))
+		assert.Equal(t, transferCounts{done: 3}, importCounts)
+
+		lemma, err := target.GetLemma("hello")
+		assert.NoError(t, err)
+		assert.Equal(t, []*parser.Lemma{{Lemma: "hello"}}, lemma.Lemmas)
+		lemmaID, suggestions, err := mustGetQuery(t, target, "helo").Return()
+		assert.Equal(t, ErrSuggestions, err)
+		assert.Equal(t, "", lemmaID)
+		assert.Equal(t, []string{"hello"}, suggestions)
+		entry, err := target.Store.Get(marshalKey("helo", queryKey))
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Minute)
+		// records are written in encoding of target
+		assert.True(t, isJSONRecord(entry.Value))
+	}
+}
+
+func mustGetQuery(t *testing.T, s *Storage, query string) *CachedQuery {
+	cached, err := s.GetQuery(query)
+	if err != nil {
+		t.Fatalf("can not get query: %v", err)
+	}
+	return cached
+}
+
+func TestImportMergeStrategies(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	exportLine := func(lemmaID string, createdAt time.Time) string {
+		value, err := json.Marshal(&CachedLemma{
+			Lemmas:        []*parser.Lemma{{Lemma: "imported"}},
+			CreatedAt:     createdAt,
+			SchemaVersion: CurrentSchemaVersion,
+		})
+		assert.NoError(t, err)
+		line, err := json.Marshal(&ExportRecord{Type: recordTypeLemma, Key: lemmaID, CreatedAt: createdAt, Value: value})
+		assert.NoError(t, err)
+		return string(line) + "\n"
+	}
+	exported := exportLine("old", newer) + exportLine("new", older) + exportLine("missing", older)
+
+	testCases := map[MergeStrategy]map[string]string{
+		MergeKeepNewer:    {"old": "imported", "new": "stored", "missing": "imported"},
+		MergeOverwrite:    {"old": "imported", "new": "imported", "missing": "imported"},
+		MergeSkipExisting: {"old": "stored", "new": "stored", "missing": "imported"},
+	}
+	for strategy, expected := range testCases {
+		storage := &Storage{Store: store.NewMemory(0)}
+		putAgedLemma(t, storage, "old", []*parser.Lemma{{Lemma: "stored"}}, time.Hour*2)
+		putAgedLemma(t, storage, "new", []*parser.Lemma{{Lemma: "stored"}}, 0)
+		// record of newer version that shares the cache is older than imported one
+		newerSchema := &Storage{Store: storage.Store, Migrator: newTestMigrator(t, upperLemmaMigration)}
+		assert.NoError(t, newerSchema.PutLemma("newer", []*parser.Lemma{{Lemma: "STORED"}}, nil))
+
+		var counts transferCounts
+		exported := exported + exportLine("newer", newer.Add(time.Hour))
+		assert.NoError(t, storage.Import(strings.NewReader(exported+"not json\n"), strategy, counts.report))
+		assert.Equal(t, 1, counts.failed)
+		for lemmaID, word := range expected {
+			lemma, err := storage.GetLemma(lemmaID)
+			assert.NoError(t, err)
+			assert.Equal(t, word, lemma.Lemmas[0].Lemma, "strategy %d, lemma %s", strategy, lemmaID)
+		}
+		lemma, err := newerSchema.GetLemma("newer")
+		assert.NoError(t, err)
+		assert.Equal(t, "STORED", lemma.Lemmas[0].Lemma, "strategy %d must not downgrade record", strategy)
+	}
+}
+
+func TestParseTransferOptions(t *testing.T) {
+	format, err := ParseExportFormat("tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, ExportTarGz, format)
+	_, err = ParseExportFormat("zip")
+	assert.Error(t, err)
+	strategy, err := ParseMergeStrategy("skip")
+	assert.NoError(t, err)
+	assert.Equal(t, MergeSkipExisting, strategy)
+	_, err = ParseMergeStrategy("merge")
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/darkclainer/camgo/pkg/parser"
+)
+
+// WarmOptions specifies how Cached.Warm prefetches queries
+type WarmOptions struct {
+	// Concurrency specifies how many queries are fetched at the same time.
+	// Zero value means one. Requests are still limited by rate limiter of querier
+	Concurrency int
+	// Progress is called after every query, it can be nil
+	Progress func(*WarmProgress)
+}
+
+// WarmProgress is state of Cached.Warm after one more query was processed
+type WarmProgress struct {
+	// Query is the last processed query
+	Query string
+	// Done is number of processed queries out of Total
+	Done  int
+	Total int
+	// Skipped queries were fresh in cache, Failed are those that were not cached
+	Skipped int
+	Failed  int
+}
+
+// WarmFailure is query that was not cached with its error
+type WarmFailure struct {
+	Query string
+	Err   error
+}
+
+// WarmNotFound is query that has no lemma in dictionary
+type WarmNotFound struct {
+	Query       string
+	Suggestions []string
+}
+
+// WarmReport is result of Cached.Warm
+type WarmReport struct {
+	// Total is number of unique non-empty queries
+	Total int
+	// Skipped queries were already fresh in cache and were not fetched
+	Skipped int
+	// Fetched queries were fetched and cached, including NotFound ones
+	Fetched int
+	// Interrupted queries were not processed because context was done
+	Interrupted int
+	// NotFound queries are cached, but dictionary has no lemma for them
+	NotFound []WarmNotFound
+	// Failures are queries without lemmas because of errors. Transient errors are not cached,
+	// so these queries are fetched again by the next run
+	Failures []WarmFailure
+}
+
+// Warm prefetches lemmas of queries to cache.
+// Queries whose search and lemma records are fresh are skipped without requests,
+// so interrupted warming can be resumed by calling Warm with the same queries again.
+// Stale records are fetched again, but transient errors don't replace them.
+func (c *Cached) Warm(ctx context.Context, queries []string, opts *WarmOptions) *WarmReport {
+	if opts == nil {
+		opts = &WarmOptions{}
+	}
+	normalized := make([]string, 0, len(queries))
+	for _, query := range queries {
+		normalized = append(normalized, c.config.Normalization.Normalize(query))
+	}
+	queries = uniqueQueries(normalized)
+	report := &WarmReport{Total: len(queries)}
+	progress := &WarmProgress{Total: len(queries)}
+	done := func(query string) {
+		progress.Query = query
+		progress.Done++
+		progress.Skipped = report.Skipped
+		progress.Failed = len(report.Failures)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	pending := make([]string, 0, len(queries))
+	for _, query := range queries {
+		warm, suggestions, err := c.isWarm(query)
+		if !warm {
+			pending = append(pending, query)
+			continue
+		}
+		report.Skipped++
+		switch {
+		case errors.Is(err, ErrSuggestions) || errors.Is(err, ErrEmptyLemmaID):
+			report.NotFound = append(report.NotFound, WarmNotFound{Query: query, Suggestions: suggestions})
+		case err != nil:
+			// error is cached until its TTL expires
+			report.Failures = append(report.Failures, WarmFailure{Query: query, Err: err})
+		}
+		done(query)
+	}
+
+	results := lookupMany(ctx, warmQuerier{c}, pending, &LookupOptions{
+		Concurrency: opts.Concurrency,
+		Priority:    PriorityBatch,
+	})
+	for result := range results {
+		switch {
+		case result.Err == nil:
+			report.Fetched++
+		case errors.Is(result.Err, ErrSuggestions) || errors.Is(result.Err, ErrEmptyLemmaID):
+			report.Fetched++
+			report.NotFound = append(report.NotFound, WarmNotFound{Query: result.Query, Suggestions: result.Suggestions})
+		case isContextError(result.Err) && ctx.Err() != nil:
+			report.Interrupted++
+		default:
+			report.Failures = append(report.Failures, WarmFailure{Query: result.Query, Err: result.Err})
+		}
+		done(result.Query)
+	}
+	return report
+}
+
+// isWarm reports if query and its lemma are fresh in cache. Returned suggestions and error
+// are those that were cached for query or its lemma
+func (c *Cached) isWarm(query string) (bool, []string, error) {
+	cachedQuery, ok := c.freshQuery(query)
+	if !ok {
+		return false, nil, nil
+	}
+	lemmaID, suggestions, err := cachedQuery.Return()
+	if err != nil {
+		return true, suggestions, err
+	}
+	if lemmaID == "" {
+		return true, suggestions, ErrEmptyLemmaID
+	}
+	cachedLemma, ok := c.freshLemma(lemmaID)
+	if !ok {
+		return false, nil, nil
+	}
+	_, err = cachedLemma.Return()
+	return true, suggestions, err
+}
+
+// freshQuery returns query if it's cached and fresh. Cached errors are fresh until they expire
+func (c *Cached) freshQuery(query string) (*CachedQuery, bool) {
+	cached, err := c.storage.GetQuery(query)
+	if err != nil {
+		return nil, false
+	}
+	return cached, cached.Error != "" || c.config.Freshness.check(queryKey, cached.CreatedAt) == fresh
+}
+
+// freshLemma returns lemma if it's cached and fresh the same way as freshQuery
+func (c *Cached) freshLemma(lemmaID string) (*CachedLemma, bool) {
+	cached, err := c.storage.GetLemma(lemmaID)
+	if err != nil {
+		return nil, false
+	}
+	return cached, cached.Error != "" || c.config.Freshness.check(lemmaKey, cached.CreatedAt) == fresh
+}
+
+// warmQuerier looks up queries for Warm. Only records that are not fresh are fetched,
+// stale ones are fetched immediately instead of in background, but transient errors don't replace them.
+// Fresh lemma shared by several queries is not fetched again
+type warmQuerier struct {
+	*Cached
+}
+
+func (w warmQuerier) Lookup(ctx context.Context, query string) (*LookupResult, error) {
+	return lookupWith(ctx, query, w.search, w.getLemma)
+}
+
+func (w warmQuerier) search(ctx context.Context, query string) (lemmaID string, suggestions []string, hit bool, err error) {
+	if _, ok := w.freshQuery(query); !ok {
+		ctx = WithRefresh(ctx)
+	}
+	return w.Cached.search(ctx, query)
+}
+
+func (w warmQuerier) getLemma(ctx context.Context, lemmaID string) (lemmas []*parser.Lemma, hit bool, err error) {
+	if _, ok := w.freshLemma(lemmaID); !ok {
+		ctx = WithRefresh(ctx)
+	}
+	return w.Cached.getLemma(ctx, lemmaID)
+}
+
+// uniqueQueries returns trimmed non-empty queries without duplicates in their original order
+func uniqueQueries(queries []string) []string {
+	seen := make(map[string]bool, len(queries))
+	unique := make([]string, 0, len(queries))
+	for _, query := range queries {
+		query = strings.TrimSpace(query)
+		if query == "" || seen[query] {
+			continue
+		}
+		seen[query] = true
+		unique = append(unique, query)
+	}
+	return unique
+}
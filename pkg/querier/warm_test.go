@@ -0,0 +1,88 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/darkclainer/camgo/pkg/mocks"
+	"github.com/darkclainer/camgo/pkg/parser"
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+func TestCachedWarm(t *testing.T) {
+	lemmas := []*parser.Lemma{{Lemma: "word"}}
+	memory := store.NewMemory(0)
+	storage := NewStorage(memory, nil)
+	assert.NoError(t, storage.PutQuery("cached", "cached", nil, nil))
+	assert.NoError(t, storage.PutLemma("cached", lemmas, nil))
+
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "word").Return("word", []string(nil), nil).Once()
+	q.On("GetLemma", mock.Anything, "word").Return(lemmas, nil).Once()
+	q.On("Search", mock.Anything, "wrod").Return("", []string{"word"}, ErrSuggestions).Once()
+	q.On("Search", mock.Anything, "broken").
+		Return("", []string(nil), &StatusError{StatusCode: http.StatusServiceUnavailable}).Twice()
+	cached := NewCached(q, memory, nil)
+
+	var progress []WarmProgress
+	queries := []string{"cached", "word", " word ", "", "wrod", "broken"}
+	report := cached.Warm(context.TODO(), queries, &WarmOptions{
+		Concurrency: 2,
+		Progress: func(p *WarmProgress) {
+			progress = append(progress, *p)
+		},
+	})
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 2, report.Fetched)
+	assert.Equal(t, []WarmNotFound{{Query: "wrod", Suggestions: []string{"word"}}}, report.NotFound)
+	if assert.Len(t, report.Failures, 1) {
+		assert.Equal(t, "broken", report.Failures[0].Query)
+		assert.Equal(t, ErrorKindServer, ClassifyError(report.Failures[0].Err))
+	}
+	if assert.Len(t, progress, 4) {
+		assert.Equal(t, WarmProgress{Query: "cached", Done: 1, Total: 4, Skipped: 1}, progress[0])
+		last := progress[3]
+		assert.Equal(t, 4, last.Done)
+		assert.Equal(t, 1, last.Failed)
+	}
+
+	// the second run resumes with queries that were not cached
+	report = cached.Warm(context.TODO(), queries, nil)
+	assert.Equal(t, 3, report.Skipped)
+	assert.Equal(t, 0, report.Fetched)
+	assert.Len(t, report.NotFound, 1)
+	assert.Len(t, report.Failures, 1)
+	q.AssertExpectations(t)
+}
+
+func TestCachedWarmSharedLemma(t *testing.T) {
+	memory := store.NewMemory(0)
+	storage := NewStorage(memory, nil)
+	assert.NoError(t, storage.PutLemma("word", []*parser.Lemma{{Lemma: "word"}}, nil))
+
+	// both queries are fetched, but their fresh lemma is not
+	q := &mocks.QueryInterface{}
+	q.On("Search", mock.Anything, "word").Return("word", []string(nil), nil).Once()
+	q.On("Search", mock.Anything, "words").Return("word", []string(nil), nil).Once()
+	cached := NewCached(q, memory, nil)
+	report := cached.Warm(context.TODO(), []string{"word", "words"}, nil)
+	assert.Equal(t, 2, report.Fetched)
+	assert.Empty(t, report.Failures)
+	q.AssertExpectations(t)
+}
+
+func TestCachedWarmInterrupted(t *testing.T) {
+	q := &mocks.QueryInterface{}
+	cached := NewCached(q, store.NewMemory(0), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := cached.Warm(ctx, []string{"one", "two"}, nil)
+	assert.Equal(t, 2, report.Interrupted)
+	assert.Empty(t, report.Failures)
+	q.AssertExpectations(t)
+}
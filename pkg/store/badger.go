@@ -0,0 +1,181 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// Badger is CacheStore on top of badger database
+type Badger struct {
+	DB *badger.DB
+	// dir is directory of database if it's known
+	dir string
+}
+
+// NewBadger returns store that uses db. db is closed with store
+func NewBadger(db *badger.DB) *Badger {
+	return &Badger{DB: db}
+}
+
+// OpenBadger opens badger database in dir
+func OpenBadger(dir string) (*Badger, error) {
+	return OpenBadgerWithOptions(dir, nil)
+}
+
+// OpenBadgerWithOptions opens badger database in dir with options, nil options are default ones.
+// If database is encrypted with another key, ErrWrongEncryptionKey is returned
+func OpenBadgerWithOptions(dir string, options *BadgerOptions) (*Badger, error) {
+	opts := badger.DefaultOptions(dir).WithLogger(nil)
+	if options != nil {
+		opts = options.apply(opts)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, encryptionError(err)
+	}
+	b := NewBadger(db)
+	b.dir = dir
+	return b, nil
+}
+
+func (b *Badger) Get(key []byte) (*Entry, error) {
+	var entry *Entry
+	err := b.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		entry, err = badgerEntry(item)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	return entry, err
+}
+
+func (b *Badger) Put(key, value []byte, ttl time.Duration) error {
+	return b.DB.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badgerWriteEntry(key, value, ttl))
+	})
+}
+
+func (b *Badger) Delete(key []byte) error {
+	return b.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// ApplyWrites applies writes in one transaction, so they should fit in limits of badger transaction
+func (b *Badger) ApplyWrites(writes []Write) error {
+	return b.DB.Update(func(txn *badger.Txn) error {
+		for _, write := range writes {
+			var err error
+			if write.Delete {
+				err = txn.Delete(write.Key)
+			} else {
+				err = txn.SetEntry(badgerWriteEntry(write.Key, write.Value, write.TTL))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func badgerWriteEntry(key, value []byte, ttl time.Duration) *badger.Entry {
+	entry := badger.NewEntry(key, value)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry
+}
+
+func (b *Badger) Iterate(prefix []byte, fn func(entry *Entry) error) error {
+	return b.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			entry, err := badgerEntry(it.Item())
+			if err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Size returns total size of files in directory of database. If directory is unknown,
+// size estimated by badger is returned, it's updated once a minute.
+func (b *Badger) Size() (int64, error) {
+	if b.dir != "" {
+		return dirSize(b.dir)
+	}
+	lsm, vlog := b.DB.Size()
+	return lsm + vlog, nil
+}
+
+// CollectGarbage rewrites value log files while they have at least discardRatio of garbage
+func (b *Badger) CollectGarbage(discardRatio float64) error {
+	if discardRatio == 0 {
+		discardRatio = DefaultDiscardRatio
+	}
+	for {
+		err := b.DB.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrGCInMemoryMode) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Flatten compacts LSM tree of database into one level
+func (b *Badger) Flatten() error {
+	return b.DB.Flatten(runtime.NumCPU())
+}
+
+func (b *Badger) Close() error {
+	return b.DB.Close()
+}
+
+func badgerEntry(item *badger.Item) (*Entry, error) {
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{
+		Key:   item.KeyCopy(nil),
+		Value: value,
+	}
+	if expiresAt := item.ExpiresAt(); expiresAt != 0 {
+		entry.ExpiresAt = time.Unix(int64(expiresAt), 0)
+	}
+	return entry, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
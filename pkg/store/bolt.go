@@ -0,0 +1,227 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltIterateBatch is number of entries read in one transaction during iteration
+	boltIterateBatch = 256
+	// boltHeaderSize is size of expiration time stored before every value
+	boltHeaderSize = 8
+	boltFileMode   = 0600
+)
+
+var boltBucket = []byte("cache")
+
+// Bolt is CacheStore in single bbolt file. Bolt has no expiration of keys,
+// so expiration time is stored with value and expired entries are removed by DeleteExpired
+// or when they are overwritten.
+type Bolt struct {
+	DB *bolt.DB
+}
+
+// OpenBolt opens or creates bbolt database in file path
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, boltFileMode, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return NewBolt(db)
+}
+
+// NewBolt returns store that uses db. db is closed with store
+func NewBolt(db *bolt.DB) (*Bolt, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{DB: db}, nil
+}
+
+func (b *Bolt) Get(key []byte) (*Entry, error) {
+	var entry *Entry
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get(key)
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		entry, err = decodeBoltEntry(key, value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired(entry.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return entry, nil
+}
+
+func (b *Bolt) Put(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return bolt.ErrKeyRequired
+	}
+	encoded := encodeBoltValue(value, ttl)
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, encoded)
+	})
+}
+
+func (b *Bolt) Delete(key []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+// ApplyWrites applies writes in one transaction
+func (b *Bolt) ApplyWrites(writes []Write) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, write := range writes {
+			var err error
+			if write.Delete {
+				err = bucket.Delete(write.Key)
+			} else {
+				err = bucket.Put(write.Key, encodeBoltValue(write.Value, write.TTL))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encodeBoltValue prepends expiration time of entry stored now with ttl to value
+func encodeBoltValue(value []byte, ttl time.Duration) []byte {
+	encoded := make([]byte, boltHeaderSize+len(value))
+	if expiresAt := expiresAt(ttl); !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(encoded, uint64(expiresAt.UnixNano()))
+	}
+	copy(encoded[boltHeaderSize:], value)
+	return encoded
+}
+
+// Iterate reads entries in batches, so fn is called outside of transaction and can modify store
+func (b *Bolt) Iterate(prefix []byte, fn func(entry *Entry) error) error {
+	seek := prefix
+	skipSeek := false
+	for {
+		batch, err := b.readBatch(prefix, seek, skipSeek)
+		if err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			if expired(entry.ExpiresAt) {
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if len(batch) < boltIterateBatch {
+			return nil
+		}
+		seek = batch[len(batch)-1].Key
+		skipSeek = true
+	}
+}
+
+// readBatch reads entries with prefix starting from seek. If skipSeek is true, seek itself is skipped
+func (b *Bolt) readBatch(prefix, seek []byte, skipSeek bool) ([]*Entry, error) {
+	batch := make([]*Entry, 0, boltIterateBatch)
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		key, value := cursor.Seek(seek)
+		if skipSeek && bytes.Equal(key, seek) {
+			key, value = cursor.Next()
+		}
+		for ; key != nil && bytes.HasPrefix(key, prefix) && len(batch) < boltIterateBatch; key, value = cursor.Next() {
+			entry, err := decodeBoltEntry(key, value)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, entry)
+		}
+		return nil
+	})
+	return batch, err
+}
+
+// DeleteExpired removes expired entries and returns their number
+func (b *Bolt) DeleteExpired() (int, error) {
+	deleted := 0
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for key, value := cursor.First(); key != nil; {
+			entry, err := decodeBoltEntry(key, value)
+			if err != nil {
+				return err
+			}
+			if !expired(entry.ExpiresAt) {
+				key, value = cursor.Next()
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			deleted++
+			// cursor points to the next entry after deletion, but it's not returned
+			key, value = cursor.Seek(entry.Key)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// Size returns size of database file
+func (b *Bolt) Size() (int64, error) {
+	info, err := os.Stat(b.DB.Path())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// CollectGarbage removes expired entries, their pages are reused by bolt, but file doesn't shrink
+func (b *Bolt) CollectGarbage(float64) error {
+	_, err := b.DeleteExpired()
+	return err
+}
+
+// Path returns path of database file
+func (b *Bolt) Path() string {
+	return b.DB.Path()
+}
+
+func (b *Bolt) Close() error {
+	return b.DB.Close()
+}
+
+var errCorruptedBoltEntry = errors.New("entry is too short")
+
+// decodeBoltEntry copies key and value, because they are valid only during transaction
+func decodeBoltEntry(key, value []byte) (*Entry, error) {
+	if len(value) < boltHeaderSize {
+		return nil, errCorruptedBoltEntry
+	}
+	entry := &Entry{
+		Key:   copyBytes(key),
+		Value: copyBytes(value[boltHeaderSize:]),
+	}
+	if expiresAt := binary.BigEndian.Uint64(value); expiresAt != 0 {
+		entry.ExpiresAt = time.Unix(0, int64(expiresAt))
+	}
+	return entry, nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// ErrWrongEncryptionKey is returned when encrypted badger database is opened with another key or without key
+var ErrWrongEncryptionKey = errors.New("wrong encryption key")
+
+// ErrNotEncrypted is returned by RotateBadgerKey for database that was created without key
+var ErrNotEncrypted = errors.New("database is not encrypted")
+
+// BadgerOptions are options of OpenBadgerWithOptions.
+//
+// EncryptionKey enables AES encryption of every table and value log file of database,
+// so every record is protected: queries, lemmas, raw HTML pages if they are archived,
+// indexes and aliases. File names, their sizes and number of records are not protected.
+// Records are decrypted when they are read, so exports and memory cache of process are plain.
+// Database created without key can't be encrypted later, export it and import into encrypted one.
+type BadgerOptions struct {
+	// EncryptionKey is master key of AES-128, AES-192 or AES-256, it's 16, 24 or 32 bytes long
+	EncryptionKey []byte
+	// KeyRotation specifies how often new data key is generated, zero means badger default of 10 days.
+	// Data keys are stored in database encrypted with master key
+	KeyRotation time.Duration
+	// ReadOnly opens database without writes, so it can be shared by several processes,
+	// like snapshot under user cache. Put and Delete of read-only database return error
+	ReadOnly bool
+}
+
+func (o *BadgerOptions) apply(opts badger.Options) badger.Options {
+	opts = opts.WithEncryptionKey(o.EncryptionKey)
+	if o.KeyRotation > 0 {
+		opts = opts.WithEncryptionKeyRotationDuration(o.KeyRotation)
+	}
+	if o.ReadOnly {
+		opts = opts.WithReadOnly(true)
+	}
+	return opts
+}
+
+// encryptionError replaces badger errors of encryption with ours
+func encryptionError(err error) error {
+	switch {
+	case errors.Is(err, badger.ErrEncryptionKeyMismatch) ||
+		strings.Contains(err.Error(), badger.ErrEncryptionKeyMismatch.Error()):
+		return ErrWrongEncryptionKey
+	case errors.Is(err, badger.ErrInvalidEncryptionKey) ||
+		strings.Contains(err.Error(), badger.ErrInvalidEncryptionKey.Error()):
+		return fmt.Errorf("encryption key should be 16, 24 or 32 bytes: %w", err)
+	}
+	return err
+}
+
+// ParseEncryptionKey decodes hex encoded key of 16, 24 or 32 bytes
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key should be hex encoded: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32: // nolint:gomnd // AES key sizes
+		return key, nil
+	default:
+		return nil, fmt.Errorf("encryption key should be 16, 24 or 32 bytes, got %d", len(key))
+	}
+}
+
+// GenerateEncryptionKey returns hex encoded random key of AES-256
+func GenerateEncryptionKey() (string, error) {
+	key := make([]byte, 32) // nolint:gomnd // AES-256
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// RotateBadgerKey re-encrypts data keys of badger database in dir with newKey.
+// Database must not be opened by anyone. Records themselves are not rewritten,
+// so rotation is fast, but oldKey can't decrypt database anymore
+func RotateBadgerKey(dir string, oldKey, newKey []byte) error {
+	if len(oldKey) == 0 {
+		return ErrNotEncrypted
+	}
+	if len(newKey) == 0 {
+		return errors.New("new encryption key is empty")
+	}
+	// opening checks that oldKey is right and that database is not used by another process
+	db, err := OpenBadgerWithOptions(dir, &BadgerOptions{EncryptionKey: oldKey})
+	if err != nil {
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	registryOpts := badger.KeyRegistryOptions{
+		Dir:           dir,
+		ReadOnly:      true,
+		EncryptionKey: oldKey,
+	}
+	registry, err := badger.OpenKeyRegistry(registryOpts)
+	if err != nil {
+		return encryptionError(err)
+	}
+	registryOpts.ReadOnly = false
+	registryOpts.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(registry, registryOpts); err != nil {
+		return encryptionError(err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// tombstonePrefix is prefix of keys in top layer that mark keys deleted from base layer
+var tombstonePrefix = []byte("\xff\xfftombstone\x00")
+
+func tombstoneKey(key []byte) []byte {
+	result := make([]byte, 0, len(tombstonePrefix)+len(key))
+	result = append(result, tombstonePrefix...)
+	return append(result, key...)
+}
+
+// Layered is CacheStore that writes to top layer and reads from top layer and then from base layer,
+// so base layer, like read-only snapshot, is never changed. Keys deleted from base layer are hidden
+// by tombstones in top layer.
+type Layered struct {
+	Top  CacheStore
+	Base CacheStore
+}
+
+// NewLayered returns store with top layer over base layer. Both layers are closed with store
+func NewLayered(top, base CacheStore) *Layered {
+	return &Layered{Top: top, Base: base}
+}
+
+func (l *Layered) Get(key []byte) (*Entry, error) {
+	entry, err := l.Top.Get(key)
+	if !errors.Is(err, ErrNotFound) {
+		return entry, err
+	}
+	deleted, err := l.deleted(key)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrNotFound
+	}
+	return l.Base.Get(key)
+}
+
+func (l *Layered) deleted(key []byte) (bool, error) {
+	_, err := l.Top.Get(tombstoneKey(key))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *Layered) Put(key, value []byte, ttl time.Duration) error {
+	if err := l.Top.Put(key, value, ttl); err != nil {
+		return err
+	}
+	return l.Top.Delete(tombstoneKey(key))
+}
+
+func (l *Layered) Delete(key []byte) error {
+	if err := l.Top.Delete(key); err != nil {
+		return err
+	}
+	_, err := l.Base.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return l.Top.Put(tombstoneKey(key), nil, 0)
+}
+
+// ApplyWrites applies writes with their tombstones to top layer in one batch if top layer is Batcher
+func (l *Layered) ApplyWrites(writes []Write) error {
+	topWrites := make([]Write, 0, len(writes)*2) // nolint:gomnd // write and its tombstone
+	for _, write := range writes {
+		if !write.Delete {
+			topWrites = append(topWrites, write, Write{Key: tombstoneKey(write.Key), Delete: true})
+			continue
+		}
+		topWrites = append(topWrites, write)
+		_, err := l.Base.Get(write.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		topWrites = append(topWrites, Write{Key: tombstoneKey(write.Key)})
+	}
+	return Apply(l.Top, topWrites)
+}
+
+// Iterate merges entries of both layers, entries of top layer replace entries of base layer.
+// Entries of top layer are read before iteration, so changes of top layer made by fn are not visited
+func (l *Layered) Iterate(prefix []byte, fn func(entry *Entry) error) error {
+	var top []*Entry
+	err := l.Top.Iterate(prefix, func(entry *Entry) error {
+		if !bytes.HasPrefix(entry.Key, tombstonePrefix) {
+			top = append(top, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tombstones := make(map[string]bool)
+	err = l.Top.Iterate(tombstoneKey(prefix), func(entry *Entry) error {
+		tombstones[string(entry.Key[len(tombstonePrefix):])] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = l.Base.Iterate(prefix, func(entry *Entry) error {
+		for len(top) != 0 && bytes.Compare(top[0].Key, entry.Key) <= 0 {
+			replaced := bytes.Equal(top[0].Key, entry.Key)
+			if err := fn(top[0]); err != nil {
+				return err
+			}
+			top = top[1:]
+			if replaced {
+				return nil
+			}
+		}
+		if tombstones[string(entry.Key)] {
+			return nil
+		}
+		return fn(entry)
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range top {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns total size of both layers, layers that don't report size are skipped
+func (l *Layered) Size() (int64, error) {
+	var total int64
+	for _, layer := range []CacheStore{l.Top, l.Base} {
+		if sizer, ok := layer.(Sizer); ok {
+			size, err := sizer.Size()
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+	}
+	return total, nil
+}
+
+// CollectGarbage reclaims space of top layer if it supports it
+func (l *Layered) CollectGarbage(discardRatio float64) error {
+	if collector, ok := l.Top.(Collector); ok {
+		return collector.CollectGarbage(discardRatio)
+	}
+	return nil
+}
+
+// Flatten compacts top layer if it supports it
+func (l *Layered) Flatten() error {
+	if flattener, ok := l.Top.(Flattener); ok {
+		return flattener.Flatten()
+	}
+	return nil
+}
+
+func (l *Layered) Close() error {
+	topErr := l.Top.Close()
+	baseErr := l.Base.Close()
+	switch {
+	case topErr != nil && baseErr != nil:
+		return fmt.Errorf("top layer: %s AND base layer: %s", topErr, baseErr)
+	case topErr != nil:
+		return topErr
+	default:
+		return baseErr
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Memory after it was closed
+var ErrClosed = errors.New("store is closed")
+
+// Memory is in-memory CacheStore that evicts least recently used entries.
+// It's useful for tests and short-lived programs.
+type Memory struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recency has the most recently used entry at front
+	recency *list.List
+	closed  bool
+}
+
+// NewMemory returns store that keeps at most maxEntries. Zero maxEntries means no limit
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		recency:    list.New(),
+	}
+}
+
+func (m *Memory) Get(key []byte) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	element, ok := m.entries[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	entry := element.Value.(*Entry)
+	if expired(entry.ExpiresAt) {
+		m.remove(element)
+		return nil, ErrNotFound
+	}
+	m.recency.MoveToFront(element)
+	return copyEntry(entry), nil
+}
+
+func (m *Memory) Put(key, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.put(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) Delete(key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.delete(key)
+	return nil
+}
+
+// ApplyWrites applies writes under one lock, so they are seen together
+func (m *Memory) ApplyWrites(writes []Write) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for _, write := range writes {
+		if write.Delete {
+			m.delete(write.Key)
+		} else {
+			m.put(write.Key, write.Value, write.TTL)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) put(key, value []byte, ttl time.Duration) {
+	entry := &Entry{
+		Key:       copyBytes(key),
+		Value:     copyBytes(value),
+		ExpiresAt: expiresAt(ttl),
+	}
+	if element, ok := m.entries[string(key)]; ok {
+		element.Value = entry
+		m.recency.MoveToFront(element)
+		return
+	}
+	m.entries[string(key)] = m.recency.PushFront(entry)
+	if m.maxEntries > 0 && m.recency.Len() > m.maxEntries {
+		m.remove(m.recency.Back())
+	}
+}
+
+func (m *Memory) delete(key []byte) {
+	if element, ok := m.entries[string(key)]; ok {
+		m.remove(element)
+	}
+}
+
+// Iterate calls fn for snapshot of entries, so fn can modify store
+func (m *Memory) Iterate(prefix []byte, fn func(entry *Entry) error) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var matched []*Entry
+	for _, element := range m.entries {
+		entry := element.Value.(*Entry)
+		if bytes.HasPrefix(entry.Key, prefix) && !expired(entry.ExpiresAt) {
+			matched = append(matched, copyEntry(entry))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return bytes.Compare(matched[i].Key, matched[j].Key) < 0
+	})
+	for _, entry := range matched {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.entries = nil
+	m.recency.Init()
+	return nil
+}
+
+// Size returns total size of keys and values
+func (m *Memory) Size() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var size int64
+	for element := m.recency.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*Entry)
+		size += int64(len(entry.Key) + len(entry.Value))
+	}
+	return size, nil
+}
+
+// CollectGarbage removes expired entries
+func (m *Memory) CollectGarbage(float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for element := m.recency.Front(); element != nil; {
+		next := element.Next()
+		if expired(element.Value.(*Entry).ExpiresAt) {
+			m.remove(element)
+		}
+		element = next
+	}
+	return nil
+}
+
+// Len returns number of entries including expired ones that are not evicted yet
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recency.Len()
+}
+
+func (m *Memory) remove(element *list.Element) {
+	m.recency.Remove(element)
+	delete(m.entries, string(element.Value.(*Entry).Key))
+}
+
+func copyEntry(entry *Entry) *Entry {
+	return &Entry{
+		Key:       copyBytes(entry.Key),
+		Value:     copyBytes(entry.Value),
+		ExpiresAt: entry.ExpiresAt,
+	}
+}
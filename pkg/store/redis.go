@@ -0,0 +1,204 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	// redisScanCount is hint of number of keys returned by one SCAN
+	redisScanCount = 1000
+	// redisReadBatch is number of values read by one MGET during iteration
+	redisReadBatch = 256
+	// redisHeaderSize is size of expiration time stored before every value
+	redisHeaderSize = 8
+)
+
+var errCorruptedRedisEntry = errors.New("entry is too short")
+
+// Redis is CacheStore in Redis or any server that speaks its protocol, so several processes
+// can share one cache. Keys are stored with Prefix, so one server can hold caches of different applications.
+//
+// Redis expires keys itself, but expiration time is also stored with value, like in Bolt,
+// so entries expire at the same time for every client even if server expires keys lazily.
+// Iterate scans every key with prefix and sorts them in memory, it's slow for big caches.
+type Redis struct {
+	Client *redis.Client
+	Prefix string
+}
+
+// NewRedis returns store that uses client and keeps keys with prefix. client is closed with store
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{Client: client, Prefix: prefix}
+}
+
+// OpenRedis connects to server by url like redis://:password@localhost:6379/0 and checks connection
+func OpenRedis(url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return NewRedis(client, prefix), nil
+}
+
+func (r *Redis) Get(key []byte) (*Entry, error) {
+	value, err := r.Client.Get(r.Prefix + string(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry, err := decodeRedisEntry(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if expired(entry.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return entry, nil
+}
+
+func (r *Redis) Put(key, value []byte, ttl time.Duration) error {
+	encoded, ttl := encodeRedisValue(value, ttl)
+	return r.Client.Set(r.Prefix+string(key), encoded, ttl).Err()
+}
+
+func (r *Redis) Delete(key []byte) error {
+	return r.Client.Del(r.Prefix + string(key)).Err()
+}
+
+// ApplyWrites applies writes in one MULTI/EXEC transaction
+func (r *Redis) ApplyWrites(writes []Write) error {
+	_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, write := range writes {
+			if write.Delete {
+				pipe.Del(r.Prefix + string(write.Key))
+				continue
+			}
+			encoded, ttl := encodeRedisValue(write.Value, write.TTL)
+			pipe.Set(r.Prefix+string(write.Key), encoded, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// encodeRedisValue prepends expiration time of entry stored now with ttl to value
+// and returns ttl that redis supports
+func encodeRedisValue(value []byte, ttl time.Duration) ([]byte, time.Duration) {
+	encoded := make([]byte, redisHeaderSize+len(value))
+	if expiresAt := expiresAt(ttl); !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(encoded, uint64(expiresAt.UnixNano()))
+	}
+	copy(encoded[redisHeaderSize:], value)
+	if ttl > 0 && ttl < time.Millisecond {
+		// redis doesn't support shorter expiration
+		ttl = time.Millisecond
+	}
+	return encoded, ttl
+}
+
+// Iterate reads values in batches, so fn can modify store. Keys added during iteration are not visited
+func (r *Redis) Iterate(prefix []byte, fn func(entry *Entry) error) error {
+	keys, err := r.scan(r.Prefix + string(prefix))
+	if err != nil {
+		return err
+	}
+	for len(keys) != 0 {
+		batch := keys
+		if len(batch) > redisReadBatch {
+			batch = batch[:redisReadBatch]
+		}
+		keys = keys[len(batch):]
+		values, err := r.Client.MGet(batch...).Result()
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			// key was deleted or expired after scan
+			data, ok := value.(string)
+			if !ok {
+				continue
+			}
+			entry, err := decodeRedisEntry([]byte(batch[i][len(r.Prefix):]), []byte(data))
+			if err != nil {
+				return err
+			}
+			if expired(entry.ExpiresAt) {
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan returns sorted keys with prefix, keys include Prefix of store
+func (r *Redis) scan(prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := r.Client.Scan(cursor, escapeRedisPattern(prefix)+"*", redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		// scan can return the same key several times
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (r *Redis) Close() error {
+	return r.Client.Close()
+}
+
+// escapeRedisPattern escapes special characters of glob pattern of SCAN
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func decodeRedisEntry(key, value []byte) (*Entry, error) {
+	if len(value) < redisHeaderSize {
+		return nil, errCorruptedRedisEntry
+	}
+	entry := &Entry{
+		Key:   copyBytes(key),
+		Value: copyBytes(value[redisHeaderSize:]),
+	}
+	if expiresAt := binary.BigEndian.Uint64(value); expiresAt != 0 {
+		entry.ExpiresAt = time.Unix(0, int64(expiresAt))
+	}
+	return entry, nil
+}
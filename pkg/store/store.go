@@ -0,0 +1,112 @@
+// Package store contains key-value stores that can hold cache of querier
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when key is missing or expired
+var ErrNotFound = errors.New("key not found")
+
+// Entry is stored value with its key
+type Entry struct {
+	Key   []byte
+	Value []byte
+	// ExpiresAt is zero if entry never expires
+	ExpiresAt time.Time
+}
+
+// CacheStore is key-value store with expiration of keys.
+// Expired entries are never returned, even if they are not removed yet.
+// Keys and values passed to and returned from store can be retained by caller.
+type CacheStore interface {
+	// Get returns entry of key or ErrNotFound
+	Get(key []byte) (*Entry, error)
+	// Put stores value with key. Entry expires after ttl, zero ttl means that entry never expires
+	Put(key, value []byte, ttl time.Duration) error
+	// Delete removes key, it's not an error if key is missing
+	Delete(key []byte) error
+	// Iterate calls fn for every entry with prefix in ascending order of keys.
+	// If fn returns error, iteration stops and the error is returned
+	Iterate(prefix []byte, fn func(entry *Entry) error) error
+	Close() error
+}
+
+// Sizer is implemented by stores that can report their size on disk or in memory
+type Sizer interface {
+	// Size returns size of store in bytes
+	Size() (int64, error)
+}
+
+// DefaultDiscardRatio is discard ratio that is used by Collector if zero ratio is passed
+const DefaultDiscardRatio = 0.5
+
+// Collector is implemented by stores that can reclaim space of deleted and expired entries
+type Collector interface {
+	// CollectGarbage reclaims space. discardRatio is part of file that should be garbage
+	// to rewrite the file, stores without such files ignore it.
+	CollectGarbage(discardRatio float64) error
+}
+
+// Flattener is implemented by stores with LSM tree that can be compacted into one level
+type Flattener interface {
+	Flatten() error
+}
+
+// Write is one change of store passed to Apply. It removes Key if Delete is true,
+// otherwise it stores Value with Key that expires after TTL
+type Write struct {
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+	Delete bool
+}
+
+// Batcher is implemented by stores that can apply several writes in one transaction
+type Batcher interface {
+	// ApplyWrites applies writes in order, so either every write is applied or none of them
+	ApplyWrites(writes []Write) error
+}
+
+// Apply applies writes to s in one transaction if s is Batcher, otherwise one by one,
+// then writes that precede failed one stay applied
+func Apply(s CacheStore, writes []Write) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	if batcher, ok := s.(Batcher); ok {
+		return batcher.ApplyWrites(writes)
+	}
+	for _, write := range writes {
+		var err error
+		if write.Delete {
+			err = s.Delete(write.Key)
+		} else {
+			err = s.Put(write.Key, write.Value, write.TTL)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiresAt returns time when entry stored now with ttl expires
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
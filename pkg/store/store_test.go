@@ -0,0 +1,292 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/store"
+	"github.com/darkclainer/camgo/pkg/store/storetest"
+)
+
+func TestBadger(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+		if err != nil {
+			t.Fatalf("can not open badger: %v", err)
+		}
+		return store.NewBadger(db)
+	})
+}
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		return store.NewMemory(0)
+	})
+}
+
+func TestBolt(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		return openBolt(t)
+	})
+}
+
+func openBolt(t *testing.T) *store.Bolt {
+	s, err := store.OpenBolt(filepath.Join(tempDir(t, "camgo-bolt"), "cache.db"))
+	if err != nil {
+		t.Fatalf("can not open bolt: %v", err)
+	}
+	return s
+}
+
+func TestMemoryEviction(t *testing.T) {
+	s := store.NewMemory(2)
+	defer s.Close()
+	assert.NoError(t, s.Put([]byte("a"), []byte("a"), 0))
+	assert.NoError(t, s.Put([]byte("b"), []byte("b"), 0))
+	// a becomes the most recently used
+	_, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put([]byte("c"), []byte("c"), 0))
+
+	assert.Equal(t, 2, s.Len())
+	_, err = s.Get([]byte("b"))
+	assert.Equal(t, store.ErrNotFound, err)
+	_, err = s.Get([]byte("a"))
+	assert.NoError(t, err)
+	_, err = s.Get([]byte("c"))
+	assert.NoError(t, err)
+}
+
+func TestBoltDeleteExpired(t *testing.T) {
+	s := openBolt(t)
+	defer s.Close()
+	assert.NoError(t, s.Put([]byte("a"), []byte("a"), 1))
+	assert.NoError(t, s.Put([]byte("b"), []byte("b"), 0))
+	assert.NoError(t, s.Put([]byte("c"), []byte("c"), 1))
+
+	deleted, err := s.DeleteExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	entry, err := s.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), entry.Value)
+}
+
+func tempDir(t *testing.T, pattern string) string {
+	dir, err := ioutil.TempDir("", pattern)
+	if err != nil {
+		t.Fatalf("can not create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestEncryptedBadger(t *testing.T) {
+	key, err := store.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f")
+	assert.NoError(t, err)
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		s, err := store.OpenBadgerWithOptions(tempDir(t, "camgo-badger"), &store.BadgerOptions{EncryptionKey: key})
+		if err != nil {
+			t.Fatalf("can not open badger: %v", err)
+		}
+		return s
+	})
+}
+
+func TestBadgerKeyRotation(t *testing.T) {
+	dir := tempDir(t, "camgo-badger")
+	oldKey := []byte("0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	open := func(key []byte) (*store.Badger, error) {
+		return store.OpenBadgerWithOptions(dir, &store.BadgerOptions{EncryptionKey: key})
+	}
+
+	s, err := open(oldKey)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put([]byte("key"), []byte("secret"), 0))
+	assert.NoError(t, s.Close())
+
+	_, err = open(newKey)
+	assert.Equal(t, store.ErrWrongEncryptionKey, err)
+	_, err = open(nil)
+	assert.Equal(t, store.ErrWrongEncryptionKey, err)
+	assert.Equal(t, store.ErrWrongEncryptionKey, store.RotateBadgerKey(dir, newKey, oldKey))
+
+	assert.NoError(t, store.RotateBadgerKey(dir, oldKey, newKey))
+	_, err = open(oldKey)
+	assert.Equal(t, store.ErrWrongEncryptionKey, err)
+	s, err = open(newKey)
+	assert.NoError(t, err)
+	entry, err := s.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), entry.Value)
+	assert.NoError(t, s.Close())
+
+	assert.Equal(t, store.ErrNotEncrypted, store.RotateBadgerKey(tempDir(t, "camgo-badger"), nil, newKey))
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key, err := store.ParseEncryptionKey(" 000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f\n")
+	assert.NoError(t, err)
+	assert.Len(t, key, 32)
+	_, err = store.ParseEncryptionKey("0001")
+	assert.Error(t, err)
+	_, err = store.ParseEncryptionKey("not hex")
+	assert.Error(t, err)
+
+	generated, err := store.GenerateEncryptionKey()
+	assert.NoError(t, err)
+	key, err = store.ParseEncryptionKey(generated)
+	assert.NoError(t, err)
+	assert.Len(t, key, 32)
+}
+
+func TestLayered(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		return store.NewLayered(store.NewMemory(0), store.NewMemory(0))
+	})
+}
+
+func TestLayeredFallThrough(t *testing.T) {
+	base := store.NewMemory(0)
+	assert.NoError(t, base.Put([]byte("a"), []byte("base a"), 0))
+	assert.NoError(t, base.Put([]byte("b"), []byte("base b"), 0))
+	assert.NoError(t, base.Put([]byte("d"), []byte("base d"), 0))
+	s := store.NewLayered(store.NewMemory(0), base)
+	defer s.Close()
+
+	entry, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("base a"), entry.Value)
+
+	assert.NoError(t, s.Put([]byte("a"), []byte("top a"), 0))
+	assert.NoError(t, s.Put([]byte("c"), []byte("top c"), 0))
+	assert.NoError(t, s.Delete([]byte("b")))
+	entry, err = s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("top a"), entry.Value)
+	_, err = s.Get([]byte("b"))
+	assert.Equal(t, store.ErrNotFound, err)
+
+	var values []string
+	assert.NoError(t, s.Iterate(nil, func(entry *store.Entry) error {
+		values = append(values, string(entry.Value))
+		return nil
+	}))
+	assert.Equal(t, []string{"top a", "top c", "base d"}, values)
+
+	// base layer is never changed and deleted key can be put again
+	entry, err = base.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("base b"), entry.Value)
+	assert.NoError(t, s.Put([]byte("b"), []byte("top b"), 0))
+	entry, err = s.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("top b"), entry.Value)
+}
+
+func TestLayeredApplyWrites(t *testing.T) {
+	base := store.NewMemory(0)
+	assert.NoError(t, base.Put([]byte("a"), []byte("base a"), 0))
+	assert.NoError(t, base.Put([]byte("b"), []byte("base b"), 0))
+	s := store.NewLayered(store.NewMemory(0), base)
+	defer s.Close()
+
+	err := s.ApplyWrites([]store.Write{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Delete: true},
+		{Key: []byte("b"), Value: []byte("top b")},
+		{Key: []byte("c"), Value: []byte("top c")},
+	})
+	assert.NoError(t, err)
+	_, err = s.Get([]byte("a"))
+	assert.Equal(t, store.ErrNotFound, err)
+	entry, err := s.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("top b"), entry.Value)
+	_, err = base.Get([]byte("a"))
+	assert.NoError(t, err, "base layer is never changed")
+}
+
+func TestReadOnlyBadger(t *testing.T) {
+	dir := tempDir(t, "camgo-badger")
+	s, err := store.OpenBadger(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put([]byte("key"), []byte("value"), 0))
+	assert.NoError(t, s.Close())
+
+	first, err := store.OpenBadgerWithOptions(dir, &store.BadgerOptions{ReadOnly: true})
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := store.OpenBadgerWithOptions(dir, &store.BadgerOptions{ReadOnly: true})
+	assert.NoError(t, err)
+	defer second.Close()
+	entry, err := second.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	assert.Error(t, first.Put([]byte("key"), []byte("changed"), 0))
+}
+
+func TestRedis(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.CacheStore {
+		return openRedis(t, runRedis(t), "camgo:")
+	})
+}
+
+func runRedis(t *testing.T) *miniredis.Miniredis {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("can not run redis: %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func openRedis(t *testing.T, server *miniredis.Miniredis, prefix string) *store.Redis {
+	s, err := store.OpenRedis("redis://"+server.Addr(), prefix)
+	if err != nil {
+		t.Fatalf("can not open redis: %v", err)
+	}
+	return s
+}
+
+func TestRedisShared(t *testing.T) {
+	server := runRedis(t)
+	first := openRedis(t, server, "camgo:")
+	defer first.Close()
+	second := openRedis(t, server, "camgo:")
+	defer second.Close()
+	// special characters of SCAN patterns in prefix match only themselves
+	other := openRedis(t, server, "*[a]?")
+	defer other.Close()
+
+	assert.NoError(t, first.Put([]byte("key"), []byte("value"), time.Hour))
+	assert.NoError(t, other.Put([]byte("key"), []byte("other"), 0))
+	entry, err := second.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Second)
+	assert.True(t, server.Exists("camgo:key"))
+	assert.Equal(t, time.Hour, server.TTL("camgo:key"))
+
+	var values []string
+	assert.NoError(t, other.Iterate(nil, func(entry *store.Entry) error {
+		values = append(values, string(entry.Value))
+		return nil
+	}))
+	assert.Equal(t, []string{"other"}, values)
+
+	assert.NoError(t, second.Delete([]byte("key")))
+	_, err = first.Get([]byte("key"))
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = store.OpenRedis(server.Addr(), "camgo:")
+	assert.Error(t, err)
+}
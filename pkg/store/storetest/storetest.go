@@ -0,0 +1,227 @@
+// Package storetest contains conformance tests that every store.CacheStore should pass
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/darkclainer/camgo/pkg/store"
+)
+
+// expirationPrecision is how precise stores keep expiration time, badger keeps it in seconds
+const expirationPrecision = time.Second
+
+// Run runs conformance tests against stores returned by newStore.
+// Every call of newStore should return new empty store, Run closes it.
+func Run(t *testing.T, newStore func(t *testing.T) store.CacheStore) { // nolint:funlen // test
+	tests := map[string]func(t *testing.T, s store.CacheStore){
+		"get missing":         testGetMissing,
+		"put and get":         testPutGet,
+		"overwrite":           testOverwrite,
+		"delete":              testDelete,
+		"ttl":                 testTTL,
+		"iterate prefix":      testIteratePrefix,
+		"iterate stop":        testIterateStop,
+		"iterate many":        testIterateMany,
+		"modify in iteration": testModifyInIteration,
+		"retain values":       testRetainValues,
+		"apply writes":        testApplyWrites,
+		"optional interfaces": testOptionalInterfaces,
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			defer func() {
+				assert.NoError(t, s.Close())
+			}()
+			test(t, s)
+		})
+	}
+}
+
+func testGetMissing(t *testing.T, s store.CacheStore) {
+	_, err := s.Get([]byte("missing"))
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testPutGet(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("key"), []byte("value"), 0))
+	assert.NoError(t, s.Put([]byte("empty"), []byte{}, 0))
+
+	entry, err := s.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key"), entry.Key)
+	assert.Equal(t, []byte("value"), entry.Value)
+	assert.True(t, entry.ExpiresAt.IsZero())
+
+	entry, err = s.Get([]byte("empty"))
+	assert.NoError(t, err)
+	assert.Empty(t, entry.Value)
+}
+
+func testOverwrite(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("key"), []byte("old"), time.Hour))
+	assert.NoError(t, s.Put([]byte("key"), []byte("new"), 0))
+
+	entry, err := s.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), entry.Value)
+	assert.True(t, entry.ExpiresAt.IsZero())
+}
+
+func testDelete(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("key"), []byte("value"), 0))
+	assert.NoError(t, s.Delete([]byte("key")))
+	assert.NoError(t, s.Delete([]byte("missing")))
+
+	_, err := s.Get([]byte("key"))
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testTTL(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("long"), []byte("value"), time.Hour))
+	assert.NoError(t, s.Put([]byte("short"), []byte("value"), time.Second))
+
+	entry, err := s.Get([]byte("long"))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, expirationPrecision*2)
+
+	time.Sleep(time.Second + expirationPrecision)
+	_, err = s.Get([]byte("short"))
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, []string{"long"}, keys(t, s, nil))
+}
+
+func testIteratePrefix(t *testing.T, s store.CacheStore) {
+	for _, key := range []string{"b2", "a", "b1", "c", "b"} {
+		assert.NoError(t, s.Put([]byte(key), []byte("value of "+key), 0))
+	}
+	assert.Equal(t, []string{"b", "b1", "b2"}, keys(t, s, []byte("b")))
+	assert.Equal(t, []string{"a", "b", "b1", "b2", "c"}, keys(t, s, nil))
+	assert.Empty(t, keys(t, s, []byte("d")))
+
+	err := s.Iterate([]byte("c"), func(entry *store.Entry) error {
+		assert.Equal(t, []byte("value of c"), entry.Value)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func testIterateStop(t *testing.T, s store.CacheStore) {
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, s.Put([]byte(key), []byte(key), 0))
+	}
+	stop := errors.New("stop")
+	var visited []string
+	err := s.Iterate(nil, func(entry *store.Entry) error {
+		visited = append(visited, string(entry.Key))
+		if string(entry.Key) == "b" {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{"a", "b"}, visited)
+}
+
+func testIterateMany(t *testing.T, s store.CacheStore) {
+	const count = 1000
+	var expected []string
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		expected = append(expected, key)
+		assert.NoError(t, s.Put([]byte(key), []byte(key), 0))
+	}
+	assert.Equal(t, expected, keys(t, s, []byte("key")))
+}
+
+func testModifyInIteration(t *testing.T, s store.CacheStore) {
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, s.Put([]byte(key), []byte(key), 0))
+	}
+	err := s.Iterate(nil, func(entry *store.Entry) error {
+		if string(entry.Key) == "b" {
+			return s.Delete(entry.Key)
+		}
+		return s.Put(entry.Key, []byte("changed"), 0)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keys(t, s, nil))
+	entry, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("changed"), entry.Value)
+}
+
+func testRetainValues(t *testing.T, s store.CacheStore) {
+	key := []byte("key")
+	value := []byte("value")
+	assert.NoError(t, s.Put(key, value, 0))
+	key[0], value[0] = 'x', 'x'
+
+	entry, err := s.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	entry.Value[0] = 'x'
+
+	entry, err = s.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+}
+
+func testApplyWrites(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("deleted"), []byte("value"), 0))
+	assert.NoError(t, s.Put([]byte("replaced"), []byte("old"), 0))
+	err := store.Apply(s, []store.Write{
+		{Key: []byte("deleted"), Delete: true},
+		{Key: []byte("replaced"), Value: []byte("new"), TTL: time.Hour},
+		{Key: []byte("added"), Value: []byte("value")},
+		{Key: []byte("readded"), Value: []byte("old")},
+		{Key: []byte("readded"), Delete: true},
+		{Key: []byte("readded"), Value: []byte("new")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"added", "readded", "replaced"}, keys(t, s, nil))
+
+	entry, err := s.Get([]byte("replaced"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), entry.Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, expirationPrecision*2)
+	entry, err = s.Get([]byte("readded"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), entry.Value)
+	assert.NoError(t, store.Apply(s, nil))
+}
+
+func testOptionalInterfaces(t *testing.T, s store.CacheStore) {
+	assert.NoError(t, s.Put([]byte("long"), []byte("value"), 0))
+	assert.NoError(t, s.Put([]byte("short"), []byte("value"), time.Nanosecond))
+	if sizer, ok := s.(store.Sizer); ok {
+		size, err := sizer.Size()
+		assert.NoError(t, err)
+		assert.True(t, size >= 0)
+	}
+	if collector, ok := s.(store.Collector); ok {
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, collector.CollectGarbage(0))
+		assert.Equal(t, []string{"long"}, keys(t, s, nil))
+	}
+	if flattener, ok := s.(store.Flattener); ok {
+		assert.NoError(t, flattener.Flatten())
+		assert.Equal(t, []string{"long"}, keys(t, s, nil))
+	}
+}
+
+func keys(t *testing.T, s store.CacheStore, prefix []byte) []string {
+	var result []string
+	err := s.Iterate(prefix, func(entry *store.Entry) error {
+		result = append(result, string(entry.Key))
+		return nil
+	})
+	assert.NoError(t, err)
+	return result
+}
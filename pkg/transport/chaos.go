@@ -0,0 +1,209 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrInjectedReset = errors.New("chaos: connection reset by peer")
+
+// Fault describes what goes wrong with one request. Zero value passes request as is
+type Fault struct {
+	// Delay is added before request is sent
+	Delay time.Duration
+	// Reset fails request with ErrInjectedReset without sending it
+	Reset bool
+	// StatusCode replaces response with empty response with this status, e.g. 429
+	StatusCode int
+	// Location replaces Location header of response, e.g. to simulate wrong redirect
+	Location string
+	// Truncate cuts body in half and fails reading of it with io.ErrUnexpectedEOF
+	Truncate bool
+	// Corrupt replaces random bytes of body
+	Corrupt bool
+}
+
+// LatencyFunc returns delay for next request
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+// ConstantLatency delays every request for d
+func ConstantLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency delays requests uniformly between min and max
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// ExponentialLatency delays requests with exponential distribution with specified mean,
+// so most requests are fast, but some are very slow
+func ExponentialLatency(mean time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// ChaosConfig specifies faults injected by Chaos middleware.
+// Faults from Script are applied to requests in order, requests after the end
+// of Script get faults drawn with specified probabilities.
+type ChaosConfig struct {
+	Script []Fault
+	// Latency specifies delay of every request that is not scripted
+	Latency LatencyFunc
+	// Probabilities of faults from 0 to 1
+	ResetRate    float64
+	StatusRate   float64
+	RedirectRate float64
+	TruncateRate float64
+	CorruptRate  float64
+	// StatusCode is used for status faults, default is 429 Too Many Requests
+	StatusCode int
+	// Location is used for redirect faults
+	Location string
+	// Seed of random generator, so probabilistic faults are reproducible
+	Seed int64
+}
+
+type chaos struct {
+	config *ChaosConfig
+	mu     sync.Mutex
+	rand   *rand.Rand
+	count  int
+}
+
+// Chaos injects faults into requests and responses
+func Chaos(config *ChaosConfig) Middleware {
+	c := &chaos{
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)), // nolint:gosec // it's not for security
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			return c.roundTrip(next, request)
+		})
+	}
+}
+
+func (c *chaos) nextFault() Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := c.count
+	c.count++
+	if index < len(c.config.Script) {
+		return c.config.Script[index]
+	}
+	var fault Fault
+	if c.config.Latency != nil {
+		fault.Delay = c.config.Latency(c.rand)
+	}
+	fault.Reset = c.rand.Float64() < c.config.ResetRate
+	if c.rand.Float64() < c.config.StatusRate {
+		fault.StatusCode = c.config.StatusCode
+		if fault.StatusCode == 0 {
+			fault.StatusCode = http.StatusTooManyRequests
+		}
+	}
+	if c.rand.Float64() < c.config.RedirectRate {
+		fault.Location = c.config.Location
+	}
+	fault.Truncate = c.rand.Float64() < c.config.TruncateRate
+	fault.Corrupt = c.rand.Float64() < c.config.CorruptRate
+	return fault
+}
+
+func (c *chaos) roundTrip(next http.RoundTripper, request *http.Request) (*http.Response, error) {
+	fault := c.nextFault()
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		}
+	}
+	if fault.Reset {
+		closeRequestBody(request)
+		return nil, ErrInjectedReset
+	}
+	if fault.StatusCode != 0 {
+		// request is never sent, but RoundTripper must close its body anyway
+		closeRequestBody(request)
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", fault.StatusCode, http.StatusText(fault.StatusCode)),
+			StatusCode: fault.StatusCode,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    request,
+		}, nil
+	}
+	response, err := next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	if fault.Location != "" {
+		response.Header.Set("Location", fault.Location)
+	}
+	if !fault.Truncate && !fault.Corrupt {
+		return response, nil
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if fault.Corrupt {
+		c.corrupt(body)
+	}
+	response.ContentLength = -1
+	response.Header.Del("Content-Length")
+	var reader io.Reader = bytes.NewReader(body)
+	if fault.Truncate {
+		reader = io.MultiReader(bytes.NewReader(body[:len(body)/2]), errReader{io.ErrUnexpectedEOF})
+	}
+	response.Body = ioutil.NopCloser(reader)
+	return response, nil
+}
+
+// corrupt replaces about every 16th byte of body with random one
+func (c *chaos) corrupt(body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range body {
+		if c.rand.Intn(16) == 0 { // nolint:gomnd // rate of corruption
+			body[i] = byte(c.rand.Intn(256)) // nolint:gomnd // any byte
+		}
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func closeRequestBody(request *http.Request) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+}
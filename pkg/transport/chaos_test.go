@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+const chaosBody = "0123456789abcdef"
+
+func okTransport() http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		header := make(http.Header)
+		header.Set("Location", "/right")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(chaosBody)),
+		}, nil
+	})
+}
+
+func TestChaosScript(t *testing.T) {
+	rt := Chain(okTransport(), Chaos(&ChaosConfig{
+		Script: []Fault{
+			{Reset: true},
+			{StatusCode: http.StatusTooManyRequests},
+			{Location: "/wrong"},
+			{Truncate: true},
+			{Corrupt: true},
+			{},
+		},
+	}))
+	_, err := doGet(t, rt, "http://example.com")
+	assert.True(t, errors.Is(err, ErrInjectedReset))
+
+	response, err := doGet(t, rt, "http://example.com")
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	}
+
+	response, err = doGet(t, rt, "http://example.com")
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, "/wrong", response.Header.Get("Location"))
+	}
+
+	response, err = doGet(t, rt, "http://example.com")
+	if assert.NoError(t, err) {
+		body, err := ioutil.ReadAll(response.Body)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+		assert.Equal(t, chaosBody[:len(chaosBody)/2], string(body))
+	}
+
+	response, err = doGet(t, rt, "http://example.com")
+	if assert.NoError(t, err) {
+		body := readBody(t, response)
+		assert.Len(t, body, len(chaosBody))
+	}
+
+	response, err = doGet(t, rt, "http://example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, chaosBody, readBody(t, response))
+	}
+}
+
+func TestChaosLatency(t *testing.T) {
+	rt := Chain(okTransport(), Chaos(&ChaosConfig{
+		Latency: ConstantLatency(time.Second),
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	assert.NoError(t, err)
+	started := time.Now()
+	_, err = rt.RoundTrip(request)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(started) < time.Second/2)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		d := UniformLatency(time.Millisecond, time.Millisecond*2)(r)
+		assert.True(t, d >= time.Millisecond && d < time.Millisecond*2)
+		assert.True(t, ExponentialLatency(time.Millisecond)(r) >= 0)
+	}
+}
+
+func TestChaosProbabilities(t *testing.T) {
+	faults := func(seed int64) []bool {
+		rt := Chain(okTransport(), Chaos(&ChaosConfig{
+			ResetRate: 0.5,
+			Seed:      seed,
+		}))
+		var resets []bool
+		for i := 0; i < 100; i++ {
+			response, err := doGet(t, rt, "http://example.com")
+			if err == nil {
+				response.Body.Close()
+			}
+			resets = append(resets, err != nil)
+		}
+		return resets
+	}
+	first := faults(42)
+	assert.Equal(t, first, faults(42), "faults must be reproducible with the same seed")
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+}
+
+func TestRetry(t *testing.T) {
+	newTransport := func(script []Fault, attempts int) http.RoundTripper {
+		return Chain(okTransport(),
+			Retry(&RetryConfig{MaxAttempts: attempts, Backoff: time.Millisecond, RateLimitedBackoff: time.Millisecond}),
+			Chaos(&ChaosConfig{Script: script}),
+		)
+	}
+	t.Run("recovers", func(t *testing.T) {
+		rt := newTransport([]Fault{{Reset: true}, {StatusCode: http.StatusTooManyRequests}}, 3)
+		response, err := doGet(t, rt, "http://example.com")
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+			assert.Equal(t, chaosBody, readBody(t, response))
+		}
+	})
+	t.Run("gives up", func(t *testing.T) {
+		rt := newTransport([]Fault{{StatusCode: http.StatusServiceUnavailable}, {StatusCode: http.StatusServiceUnavailable}}, 2)
+		response, err := doGet(t, rt, "http://example.com")
+		if assert.NoError(t, err) {
+			response.Body.Close()
+			assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		}
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		rt := newTransport([]Fault{{StatusCode: http.StatusNotFound}}, 3)
+		response, err := doGet(t, rt, "http://example.com")
+		if assert.NoError(t, err) {
+			response.Body.Close()
+			assert.Equal(t, http.StatusNotFound, response.StatusCode)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	// rateLimited returns 429 with retryAfter header on the first attempt
+	rateLimited := func(retryAfter string, attempts *int) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			*attempts++
+			if *attempts > 1 {
+				return okTransport().RoundTrip(request)
+			}
+			header := make(http.Header)
+			if retryAfter != "" {
+				header.Set("Retry-After", retryAfter)
+			}
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     header,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		})
+	}
+	t.Run("minimum delay of 429", func(t *testing.T) {
+		var attempts int
+		rt := Chain(rateLimited("", &attempts), Retry(&RetryConfig{MaxAttempts: 2, RateLimitedBackoff: time.Millisecond * 50}))
+		started := time.Now()
+		response, err := doGet(t, rt, "http://example.com")
+		if assert.NoError(t, err) {
+			response.Body.Close()
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+		}
+		assert.Equal(t, 2, attempts)
+		assert.True(t, time.Since(started) >= time.Millisecond*50, "429 must not be retried immediately")
+	})
+	t.Run("retry after exceeds deadline", func(t *testing.T) {
+		var attempts int
+		rt := Chain(rateLimited("120", &attempts), Retry(&RetryConfig{MaxAttempts: 2, RateLimitedBackoff: time.Millisecond}))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+		assert.NoError(t, err)
+		response, err := rt.RoundTrip(request)
+		if assert.NoError(t, err) {
+			response.Body.Close()
+			assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		}
+		assert.Equal(t, 1, attempts)
+	})
+	t.Run("retry after", func(t *testing.T) {
+		response := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: make(http.Header)}
+		assert.Equal(t, time.Millisecond, responseDelay(response, time.Millisecond, time.Second))
+		response.Header.Set("Retry-After", "3")
+		assert.Equal(t, time.Second*3, responseDelay(response, time.Millisecond, time.Second))
+		response.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		assert.True(t, responseDelay(response, time.Millisecond, time.Second) > time.Minute*59)
+		response.Header.Set("Retry-After", "soon")
+		response.StatusCode = http.StatusTooManyRequests
+		assert.Equal(t, time.Second, responseDelay(response, time.Millisecond, time.Second))
+	})
+}
+
+func TestRetryRateLimit(t *testing.T) {
+	var attempts int
+	rt := Chain(RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		attempts++
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}),
+		Retry(&RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond}),
+		RateLimit(rate.NewLimiter(1, 1)),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	assert.NoError(t, err)
+	_, err = rt.RoundTrip(request)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rate limit wait failed")
+	}
+	assert.Equal(t, 1, attempts, "retry must wait for rate limiter")
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrResponseTooLarge = errors.New("response is too large")
+	// ErrRateLimitWait is returned by RateLimit when token of limiter can't be got before deadline of request
+	ErrRateLimitWait = errors.New("rate limit wait failed")
+)
+
+// Logging logs every request with its result and duration using logf
+func Logging(logf func(format string, args ...interface{})) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			started := time.Now()
+			response, err := next.RoundTrip(request)
+			duration := time.Since(started)
+			if err != nil {
+				logf("%s %s failed after %s: %s", request.Method, request.URL, duration, err)
+				return nil, err
+			}
+			logf("%s %s %d in %s", request.Method, request.URL, response.StatusCode, duration)
+			return response, nil
+		})
+	}
+}
+
+// Header sets header for every request, if request does not have it already
+func Header(header map[string]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			request = request.Clone(request.Context())
+			for key, value := range header {
+				if request.Header.Get(key) == "" {
+					request.Header.Set(key, value)
+				}
+			}
+			return next.RoundTrip(request)
+		})
+	}
+}
+
+// RateLimit waits for token of limiter before every request. Place it after Retry, so retries are limited too
+func RateLimit(limiter *rate.Limiter) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			if err := limiter.Wait(request.Context()); err != nil {
+				closeRequestBody(request)
+				if ctxErr := request.Context().Err(); ctxErr != nil {
+					return nil, ctxErr
+				}
+				// limiter fails before deadline, if it sees that token comes too late
+				return nil, fmt.Errorf("%w: %s", ErrRateLimitWait, err)
+			}
+			return next.RoundTrip(request)
+		})
+	}
+}
+
+// UserAgentRotation sets User-Agent header of requests to userAgents in round robin order
+func UserAgentRotation(userAgents []string) Middleware {
+	var counter uint64
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			if len(userAgents) == 0 {
+				return next.RoundTrip(request)
+			}
+			index := (atomic.AddUint64(&counter, 1) - 1) % uint64(len(userAgents))
+			request = request.Clone(request.Context())
+			request.Header.Set("User-Agent", userAgents[index])
+			return next.RoundTrip(request)
+		})
+	}
+}
+
+// MaxResponseSize fails requests with response body larger than limit bytes.
+// If response has Content-Length, it's checked at once, otherwise reading of body fails
+// with ErrResponseTooLarge when limit is exceeded.
+func MaxResponseSize(limit int64) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			response, err := next.RoundTrip(request)
+			if err != nil {
+				return nil, err
+			}
+			if response.ContentLength > limit {
+				response.Body.Close()
+				return nil, fmt.Errorf("%w: content length %d exceeds %d", ErrResponseTooLarge, response.ContentLength, limit)
+			}
+			response.Body = &limitedBody{
+				ReadCloser: response.Body,
+				remaining:  limit,
+			}
+			return response, nil
+		})
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	// read one byte more than allowed to find out if body is larger than limit
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// Decompress asks server for gzip or brotli encoded response and decodes it.
+// Requests that already have Accept-Encoding are passed as is.
+func Decompress() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			if request.Header.Get("Accept-Encoding") != "" {
+				return next.RoundTrip(request)
+			}
+			request = request.Clone(request.Context())
+			request.Header.Set("Accept-Encoding", "gzip, br")
+			response, err := next.RoundTrip(request)
+			if err != nil {
+				return nil, err
+			}
+			if err := decodeBody(response); err != nil {
+				response.Body.Close()
+				return nil, err
+			}
+			return response, nil
+		})
+	}
+}
+
+func decodeBody(response *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	var decoded io.Reader
+	switch encoding {
+	case "":
+		return nil
+	case "gzip":
+		reader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return fmt.Errorf("can not decode gzip body: %w", err)
+		}
+		decoded = reader
+	case "br":
+		decoded = brotli.NewReader(response.Body)
+	default:
+		return fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	response.Body = &decodedBody{
+		Reader: decoded,
+		Closer: response.Body,
+	}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+	return nil
+}
+
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
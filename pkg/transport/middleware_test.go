@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func doGet(t *testing.T, rt http.RoundTripper, url string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("can not create request: %v", err)
+	}
+	return rt.RoundTrip(request)
+}
+
+func readBody(t *testing.T, response *http.Response) string {
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(request)
+			})
+		}
+	}
+	base := RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	rt := Chain(base, record("first"), record("second"))
+	response, err := doGet(t, rt, "http://example.com")
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, []string{"first", "second", "base"}, order)
+}
+
+func TestHeaderAndUserAgent(t *testing.T) {
+	var headers []http.Header
+	base := RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		headers = append(headers, request.Header)
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	rt := Chain(base,
+		Header(map[string]string{"X-Test": "value"}),
+		UserAgentRotation([]string{"first", "second"}),
+	)
+	for i := 0; i < 3; i++ {
+		response, err := doGet(t, rt, "http://example.com")
+		assert.NoError(t, err)
+		response.Body.Close()
+	}
+	var agents []string
+	for _, header := range headers {
+		assert.Equal(t, "value", header.Get("X-Test"))
+		agents = append(agents, header.Get("User-Agent"))
+	}
+	assert.Equal(t, []string{"first", "second", "first"}, agents)
+}
+
+func TestLogging(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	failing := RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		return nil, errors.New("test error")
+	})
+	_, err := doGet(t, Chain(failing, Logging(logf)), "http://example.com/path")
+	assert.Error(t, err)
+	if assert.Len(t, logs, 1) {
+		assert.Contains(t, logs[0], "http://example.com/path")
+		assert.Contains(t, logs[0], "test error")
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			// flush before writing body, so Content-Length is unknown
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+	t.Run("content length", func(t *testing.T) {
+		_, err := doGet(t, Chain(nil, MaxResponseSize(5)), server.URL)
+		assert.True(t, errors.Is(err, ErrResponseTooLarge))
+	})
+	t.Run("chunked", func(t *testing.T) {
+		response, err := doGet(t, Chain(nil, MaxResponseSize(5)), server.URL+"?chunked=1")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer response.Body.Close()
+		_, err = ioutil.ReadAll(response.Body)
+		assert.True(t, errors.Is(err, ErrResponseTooLarge))
+	})
+	t.Run("fits", func(t *testing.T) {
+		response, err := doGet(t, Chain(nil, MaxResponseSize(10)), server.URL+"?chunked=1")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "0123456789", readBody(t, response))
+	})
+}
+
+func TestDecompress(t *testing.T) {
+	const content = "compressed content"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("encoding")
+		assert.Equal(t, "gzip, br", r.Header.Get("Accept-Encoding"))
+		var buf bytes.Buffer
+		switch encoding {
+		case "gzip":
+			writer := gzip.NewWriter(&buf)
+			_, _ = writer.Write([]byte(content))
+			_ = writer.Close()
+		case "br":
+			writer := brotli.NewWriter(&buf)
+			_, _ = writer.Write([]byte(content))
+			_ = writer.Close()
+		default:
+			buf.WriteString(content)
+		}
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+	for _, encoding := range []string{"", "gzip", "br"} {
+		encoding := encoding
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			// DisableCompression prevents transparent gzip decoding by http.Transport
+			rt := Chain(&http.Transport{DisableCompression: true}, Decompress())
+			response, err := doGet(t, rt, server.URL+"?encoding="+encoding)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Empty(t, response.Header.Get("Content-Encoding"))
+			assert.Equal(t, content, readBody(t, response))
+		})
+	}
+}
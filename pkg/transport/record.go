@@ -0,0 +1,284 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+const (
+	interactionExt = ".json"
+	bodyExt        = ".body"
+)
+
+// Interaction is recorded request and response to it
+type Interaction struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	// Body is stored in separate file next to interaction
+	Body []byte `json:"-"`
+}
+
+// Recorder saves every request that passes through its middleware to directory.
+// Every interaction is saved as NNNN.json with metadata and NNNN.body with response body,
+// where NNNN is sequence number of request padded to at least four digits.
+type Recorder struct {
+	dir     string
+	mu      sync.Mutex
+	counter int
+}
+
+// NewRecorder creates dir if needed. Existing recordings in dir are kept
+// and new interactions are numbered after them.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can not create recordings dir: %w", err)
+	}
+	names, err := interactionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		dir:     dir,
+		counter: lastInteractionNumber(names),
+	}, nil
+}
+
+// lastInteractionNumber returns the highest sequence number of interaction files,
+// so recordings with gaps are not overwritten
+func lastInteractionNumber(names []string) int {
+	last := 0
+	for _, name := range names {
+		if number, ok := interactionNumber(name); ok && number > last {
+			last = number
+		}
+	}
+	return last
+}
+
+// interactionNumber returns sequence number of interaction file, false means that file isn't numbered
+func interactionNumber(name string) (int, bool) {
+	number, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), interactionExt))
+	return number, err == nil
+}
+
+// Middleware returns middleware that records interactions.
+// Response body is read completely, so it's recorded even if caller doesn't read it.
+func (r *Recorder) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			response, err := next.RoundTrip(request)
+			if err != nil {
+				return nil, err
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("can not read body for recording: %w", err)
+			}
+			response.Body = ioutil.NopCloser(bytes.NewReader(body))
+			interaction := &Interaction{
+				Method:     request.Method,
+				URL:        request.URL.String(),
+				StatusCode: response.StatusCode,
+				Header:     response.Header.Clone(),
+				Body:       body,
+			}
+			if err := r.save(interaction); err != nil {
+				return nil, fmt.Errorf("can not record interaction: %w", err)
+			}
+			return response, nil
+		})
+	}
+}
+
+func (r *Recorder) save(interaction *Interaction) error {
+	r.mu.Lock()
+	r.counter++
+	name := filepath.Join(r.dir, fmt.Sprintf("%04d", r.counter))
+	r.mu.Unlock()
+
+	meta, err := json.MarshalIndent(interaction, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+bodyExt, interaction.Body, 0o644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+interactionExt, meta, 0o644)
+}
+
+// LoadInteractions loads interactions saved by Recorder in order they were recorded
+func LoadInteractions(dir string) ([]*Interaction, error) {
+	names, err := interactionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	interactions := make([]*Interaction, 0, len(names))
+	for _, name := range names {
+		meta, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("can not read interaction: %w", err)
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(meta, &interaction); err != nil {
+			return nil, fmt.Errorf("can not decode interaction '%s': %w", name, err)
+		}
+		interaction.Body, err = ioutil.ReadFile(strings.TrimSuffix(name, interactionExt) + bodyExt)
+		if err != nil {
+			return nil, fmt.Errorf("can not read interaction body: %w", err)
+		}
+		interactions = append(interactions, &interaction)
+	}
+	return interactions, nil
+}
+
+func interactionFiles(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+interactionExt))
+	if err != nil {
+		return nil, fmt.Errorf("can not list recordings: %w", err)
+	}
+	// numbers are compared as numbers, because 10000 is wider than 9999. Files without numbers are the last
+	sort.Slice(names, func(i, j int) bool {
+		numberI, okI := interactionNumber(names[i])
+		numberJ, okJ := interactionNumber(names[j])
+		switch {
+		case okI && okJ && numberI != numberJ:
+			return numberI < numberJ
+		case okI != okJ:
+			return okI
+		default:
+			return names[i] < names[j]
+		}
+	})
+	return names, nil
+}
+
+// MatchMode specifies how Replayer finds interaction for request
+type MatchMode int
+
+const (
+	// MatchStrict replays interactions in recorded order.
+	// Every request must have the same method and URL as the next interaction.
+	MatchStrict MatchMode = iota
+	// MatchLenient finds the first not replayed interaction with the same method, path and query,
+	// ignoring scheme, host and order of query parameters. When all such interactions are replayed,
+	// the last of them is repeated.
+	MatchLenient
+)
+
+// Replayer is http.RoundTripper that responds with recorded interactions and never touches network
+type Replayer struct {
+	mode         MatchMode
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+	next         int
+}
+
+func NewReplayer(interactions []*Interaction, mode MatchMode) *Replayer {
+	return &Replayer{
+		mode:         mode,
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}
+}
+
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	r.mu.Lock()
+	var interaction *Interaction
+	if r.mode == MatchStrict {
+		interaction = r.matchStrict(request)
+	} else {
+		interaction = r.matchLenient(request)
+	}
+	r.mu.Unlock()
+	if interaction == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, request.Method, request.URL)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       request,
+	}, nil
+}
+
+// Remaining returns number of interactions that were not replayed yet
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+func (r *Replayer) matchStrict(request *http.Request) *Interaction {
+	if r.next >= len(r.interactions) {
+		return nil
+	}
+	interaction := r.interactions[r.next]
+	if interaction.Method != request.Method || interaction.URL != request.URL.String() {
+		return nil
+	}
+	r.used[r.next] = true
+	r.next++
+	return interaction
+}
+
+func (r *Replayer) matchLenient(request *http.Request) *Interaction {
+	last := -1
+	for i, interaction := range r.interactions {
+		if !lenientEqual(interaction, request) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return interaction
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil
+	}
+	return r.interactions[last]
+}
+
+func lenientEqual(interaction *Interaction, request *http.Request) bool {
+	if interaction.Method != request.Method {
+		return false
+	}
+	recorded, err := url.Parse(interaction.URL)
+	if err != nil {
+		return false
+	}
+	// Query().Encode() sorts parameters by key
+	return recorded.Path == request.URL.Path &&
+		recorded.Query().Encode() == request.URL.Query().Encode()
+}
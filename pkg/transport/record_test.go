@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRecordedDir(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "camgo-recordings")
+	if err != nil {
+		t.Fatalf("can not create temp dir: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("body of " + r.URL.RequestURI()))
+	}))
+	defer server.Close()
+
+	recorder, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("can not create recorder: %v", err)
+	}
+	rt := Chain(nil, recorder.Middleware())
+	for _, path := range []string{"/redirect", "/page?b=2&a=1", "/page?b=2&a=1"} {
+		response, err := doGet(t, rt, server.URL+path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		// recorder must not consume body
+		assert.NotEmpty(t, readBody(t, response))
+	}
+	return dir, server.URL
+}
+
+func TestRecorder(t *testing.T) {
+	dir, serverURL := newRecordedDir(t)
+	defer os.RemoveAll(dir)
+
+	interactions, err := LoadInteractions(dir)
+	if !assert.NoError(t, err) || !assert.Len(t, interactions, 3) {
+		return
+	}
+	assert.Equal(t, serverURL+"/redirect", interactions[0].URL)
+	assert.Equal(t, http.StatusFound, interactions[0].StatusCode)
+	assert.Equal(t, "/target", interactions[0].Header.Get("Location"))
+	assert.Equal(t, "body of /page?b=2&a=1", string(interactions[1].Body))
+
+	// new recorder continues numbering
+	recorder, err := NewRecorder(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, recorder.counter)
+
+	// gap in numbering doesn't make recorder overwrite the last interaction
+	assert.NoError(t, os.Remove(filepath.Join(dir, "0002"+interactionExt)))
+	recorder, err = NewRecorder(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, recorder.counter)
+}
+
+func TestLoadInteractionsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camgo-recordings")
+	if err != nil {
+		t.Fatalf("can not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	recorder := &Recorder{dir: dir, counter: 9998}
+	for _, url := range []string{"first", "second"} {
+		assert.NoError(t, recorder.save(&Interaction{URL: url}))
+	}
+
+	// 10000 is sorted after 9999, though it's less as string
+	interactions, err := LoadInteractions(dir)
+	if assert.NoError(t, err) && assert.Len(t, interactions, 2) {
+		assert.Equal(t, "first", interactions[0].URL)
+		assert.Equal(t, "second", interactions[1].URL)
+	}
+	recorder, err = NewRecorder(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, recorder.counter)
+}
+
+func TestReplayer(t *testing.T) {
+	dir, serverURL := newRecordedDir(t)
+	defer os.RemoveAll(dir)
+	interactions, err := LoadInteractions(dir)
+	if err != nil {
+		t.Fatalf("can not load interactions: %v", err)
+	}
+	t.Run("strict", func(t *testing.T) {
+		replayer := NewReplayer(interactions, MatchStrict)
+		response, err := doGet(t, replayer, serverURL+"/redirect")
+		if assert.NoError(t, err) {
+			response.Body.Close()
+			assert.Equal(t, http.StatusFound, response.StatusCode)
+			assert.Equal(t, "/target", response.Header.Get("Location"))
+		}
+		// out of order request
+		_, err = doGet(t, replayer, serverURL+"/other")
+		assert.True(t, errors.Is(err, ErrNoInteraction))
+		response, err = doGet(t, replayer, serverURL+"/page?b=2&a=1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "body of /page?b=2&a=1", readBody(t, response))
+		}
+		assert.Equal(t, 1, replayer.Remaining())
+	})
+	t.Run("lenient", func(t *testing.T) {
+		replayer := NewReplayer(interactions, MatchLenient)
+		// another host and order of parameters
+		for i := 0; i < 3; i++ {
+			response, err := doGet(t, replayer, "https://example.com/page?a=1&b=2")
+			if assert.NoError(t, err) {
+				assert.Equal(t, "body of /page?b=2&a=1", readBody(t, response))
+			}
+		}
+		assert.Equal(t, 1, replayer.Remaining())
+		_, err := doGet(t, replayer, "https://example.com/page?a=2")
+		assert.True(t, errors.Is(err, ErrNoInteraction))
+	})
+}
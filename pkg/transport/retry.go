@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultRateLimitedBackoff = time.Second
+
+// RetryConfig specifies how failed requests are retried
+type RetryConfig struct {
+	// MaxAttempts is maximum number of attempts including the first one.
+	// Values lower than two disable retries
+	MaxAttempts int
+	// Backoff is delay before the second attempt, it doubles with every next attempt
+	Backoff time.Duration
+	// RateLimitedBackoff is minimum delay before retry of 429 response, zero means one second.
+	// Longer delay requested by Retry-After header of response is always respected
+	RateLimitedBackoff time.Duration
+	// Retryable reports if request should be retried.
+	// By default network errors, 429 and 5xx responses are retried
+	Retryable func(response *http.Response, err error) bool
+}
+
+// DefaultRetryable retries network errors, except context errors, 429 and 5xx responses
+func DefaultRetryable(response *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+}
+
+// Retry repeats failed requests. Requests with body that can't be rewound are not retried.
+// Response is returned without retry if delay requested by server doesn't fit into deadline of request.
+// Rate limiting middleware should be placed after Retry, so every attempt is limited.
+func Retry(config *RetryConfig) Middleware {
+	retryable := config.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	rateLimitedBackoff := config.RateLimitedBackoff
+	if rateLimitedBackoff <= 0 {
+		rateLimitedBackoff = defaultRateLimitedBackoff
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			backoff := config.Backoff
+			for attempt := 1; ; attempt++ {
+				response, err := next.RoundTrip(request)
+				last := attempt >= config.MaxAttempts || (request.Body != nil && request.GetBody == nil)
+				if last || !retryable(response, err) {
+					return response, err
+				}
+				delay := backoff
+				if response != nil {
+					delay = responseDelay(response, backoff, rateLimitedBackoff)
+					if deadline, ok := request.Context().Deadline(); ok && time.Until(deadline) < delay {
+						return response, nil
+					}
+					response.Body.Close()
+				}
+				if err := wait(request.Context(), delay); err != nil {
+					return nil, err
+				}
+				backoff *= 2
+				if request.GetBody != nil {
+					body, err := request.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					request = request.Clone(request.Context())
+					request.Body = body
+				}
+			}
+		})
+	}
+}
+
+// responseDelay returns delay before retry of response, it's the longest of backoff,
+// delay from Retry-After header and rateLimitedBackoff for 429 responses
+func responseDelay(response *http.Response, backoff, rateLimitedBackoff time.Duration) time.Duration {
+	delay := backoff
+	if response.StatusCode == http.StatusTooManyRequests && delay < rateLimitedBackoff {
+		delay = rateLimitedBackoff
+	}
+	if retryAfter := parseRetryAfter(response.Header.Get("Retry-After")); delay < retryAfter {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter parses Retry-After header with delay in seconds or date, invalid header means zero delay
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
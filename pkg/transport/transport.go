@@ -0,0 +1,54 @@
+// Package transport contains http.RoundTripper middlewares for querier.
+package transport
+
+import (
+	"net/http"
+)
+
+// Middleware wraps next RoundTripper with additional behavior
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use ordinary function as http.RoundTripper
+type RoundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// Chain wraps base with middlewares. The first middleware sees request first and response last.
+// If base is nil, http.DefaultTransport is used.
+func Chain(base http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if len(middlewares) == 0 {
+		return base
+	}
+	var rt http.RoundTripper = base
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return &chain{
+		base: base,
+		rt:   rt,
+	}
+}
+
+type chain struct {
+	base http.RoundTripper
+	rt   http.RoundTripper
+}
+
+func (c *chain) RoundTrip(request *http.Request) (*http.Response, error) {
+	return c.rt.RoundTrip(request)
+}
+
+// CloseIdleConnections makes http.Client.CloseIdleConnections work through the chain
+func (c *chain) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if base, ok := c.base.(closeIdler); ok {
+		base.CloseIdleConnections()
+	}
+}